@@ -0,0 +1,160 @@
+package pgtest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// OptMigrations migrates the primary database when the container starts. source is a directory or glob pattern of
+// *.sql files, applied in lexical order; directories of `{version}_{name}.up.sql` migrations are applied in version
+// order and their down files ignored.
+//
+// The migrated database is cached as a dump keyed by a hash of the files and the image, so later runs restore the dump
+// instead of re-running migrations. See OptCacheDir.
+func OptMigrations(source string) Opt {
+	return func(f *Postgres) {
+		f.migrations = source
+	}
+}
+
+// OptCacheDir sets where migrated databases are cached. Defaults to a pgtest directory in the user's cache directory.
+// The cache may be shared by concurrent test runs.
+func OptCacheDir(dir string) Opt {
+	return func(f *Postgres) {
+		f.cacheDir = dir
+	}
+}
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "pgtest")
+}
+
+// migrationFiles resolves a migrations source (see OptMigrations) to the files to apply, in order.
+func migrationFiles(source string) ([]string, error) {
+	if info, err := os.Stat(source); err == nil && info.IsDir() {
+		migrations, err := ReadMigrations(source)
+		if err != nil {
+			return nil, err
+		}
+		if len(migrations) > 0 {
+			files := make([]string, len(migrations))
+			for i, m := range migrations {
+				files[i] = m.Up
+			}
+			return files, nil
+		}
+		source = filepath.Join(source, "*.sql")
+	}
+	files, err := filepath.Glob(source)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no migrations found: %v", source)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// migrationsHash identifies the database produced by applying files, in order, to a container running image.
+func migrationsHash(image string, files []string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v\x00", image)
+	for _, path := range files {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%v\x00%v\x00", filepath.Base(path), len(b))
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32], nil
+}
+
+// resolveMigrations finds the configured migration files and computes their hash.
+func (p *Postgres) resolveMigrations() ([]string, error) {
+	files, err := migrationFiles(p.migrations)
+	if err != nil {
+		return nil, err
+	}
+	p.migrationsHash, err = migrationsHash(p.repo+":"+p.version, files)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// migrate applies migration files to the primary database, restoring them from the cache if possible.
+func (p *Postgres) migrate(ctx context.Context, files []string) error {
+	dir := p.cacheDir
+	if dir == "" {
+		dir = defaultCacheDir()
+	}
+	path := filepath.Join(dir, p.migrationsHash+".pgdump")
+
+	if file, err := os.Open(path); err == nil {
+		err := p.RestoreFrom(ctx, file)
+		file.Close()
+		if err == nil {
+			p.restoredFromCache = true
+			p.log.Debug("restored migrations from cache", zap.String("hash", p.migrationsHash), zap.String("path", path), zap.String("container", p.HostName()))
+			return nil
+		}
+		// The restore is a single transaction, so the database is still empty.
+		p.log.Warn("failed to restore migrations from cache", zap.String("path", path), zap.Error(err))
+	}
+
+	db, err := p.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, file := range files {
+		if err := applyFile(ctx, db, file); err != nil {
+			return err
+		}
+	}
+	p.log.Debug("applied migrations", zap.Int("files", len(files)), zap.String("hash", p.migrationsHash), zap.String("container", p.HostName()))
+
+	if err := p.cacheMigrations(ctx, path); err != nil {
+		p.log.Warn("failed to cache migrations", zap.String("path", path), zap.Error(err))
+	}
+	return nil
+}
+
+// cacheMigrations dumps the primary database to path. The dump is written to a temporary file and renamed into
+// place, so concurrent runs never see a partial dump; if several runs miss at once, the last rename wins.
+func (p *Postgres) cacheMigrations(ctx context.Context, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := p.DumpTo(ctx, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// MigrationsHash returns the cache key of the migrations applied by OptMigrations, or an empty string.
+func (p *Postgres) MigrationsHash() string {
+	return p.migrationsHash
+}
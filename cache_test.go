@@ -0,0 +1,58 @@
+package pgtest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationFiles(t *testing.T) {
+	files, err := migrationFiles("testdata/updown")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join("testdata/updown", "1_address.up.sql"),
+		filepath.Join("testdata/updown", "2_person.up.sql"),
+		filepath.Join("testdata/updown", "10_person_email.up.sql"),
+	}, files)
+
+	files, err = migrationFiles("testdata/migrations")
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	_, err = migrationFiles("testdata/nope/*.sql")
+	assert.Error(t, err)
+}
+
+func TestMigrationsHash(t *testing.T) {
+	files, err := migrationFiles("testdata/updown")
+	require.NoError(t, err)
+	a, err := migrationsHash("postgres:13-alpine", files)
+	require.NoError(t, err)
+	b, err := migrationsHash("postgres:13-alpine", files)
+	require.NoError(t, err)
+	assert.Equal(t, a, b)
+	assert.Len(t, a, 32)
+
+	c, err := migrationsHash("postgres:14-alpine", files)
+	require.NoError(t, err)
+	assert.NotEqual(t, a, c)
+	d, err := migrationsHash("postgres:13-alpine", files[:2])
+	require.NoError(t, err)
+	assert.NotEqual(t, a, d)
+}
+
+func TestImageCacheTag(t *testing.T) {
+	p := &Postgres{}
+	OptImageCache()(p)
+	p.version = "13-alpine"
+	p.migrationsHash = "0123456789abcdef0123456789abcdef"
+	p.defaults()
+	assert.Equal(t, committedPassword, p.settings.Password)
+	tag := p.imageCacheTag()
+	assert.Regexp(t, `^13-alpine-[0-9a-f]{16}$`, tag)
+
+	p.settings = &ConnectionSettings{User: "postgres", Password: "secret", Database: "postgres"}
+	assert.NotEqual(t, tag, p.imageCacheTag())
+}
@@ -59,7 +59,14 @@ func (cs *ConnectionSettings) Config() (*pgx.ConnConfig, error) {
 }
 
 func (cs *ConnectionSettings) PoolConfig() (*pgxpool.Config, error) {
-	return pgxpool.ParseConfig(cs.String())
+	config, err := pgxpool.ParseConfig(cs.String())
+	if err != nil {
+		return nil, err
+	}
+	if cs.MaxOpenConns > 0 {
+		config.MaxConns = int32(cs.MaxOpenConns)
+	}
+	return config, nil
 }
 
 func (cs *ConnectionSettings) Copy() *ConnectionSettings {
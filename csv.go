@@ -0,0 +1,144 @@
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+)
+
+// CSVOptions configures COPY ... WITH (FORMAT csv). Zero values use postgres' defaults.
+type CSVOptions struct {
+	// Header expects (CopyIn) or writes (CopyOut) a header line.
+	Header bool
+	// Delimiter defaults to a comma.
+	Delimiter rune
+	// Quote defaults to a double quote.
+	Quote rune
+	// Escape defaults to the quote character.
+	Escape rune
+	// Null is the string representing NULL. Defaults to an unquoted empty string.
+	Null string
+	// Columns limits the copy to the given columns of a table, in order. Defaults to all columns.
+	Columns []string
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func (o CSVOptions) clause() string {
+	opts := []string{"FORMAT csv"}
+	if o.Header {
+		opts = append(opts, "HEADER true")
+	}
+	if o.Delimiter != 0 {
+		opts = append(opts, "DELIMITER "+quoteLiteral(string(o.Delimiter)))
+	}
+	if o.Quote != 0 {
+		opts = append(opts, "QUOTE "+quoteLiteral(string(o.Quote)))
+	}
+	if o.Escape != 0 {
+		opts = append(opts, "ESCAPE "+quoteLiteral(string(o.Escape)))
+	}
+	if o.Null != "" {
+		opts = append(opts, "NULL "+quoteLiteral(o.Null))
+	}
+	return "(" + strings.Join(opts, ", ") + ")"
+}
+
+func (o CSVOptions) target(table string) string {
+	schema, name := splitQualifiedName(table)
+	target := pgx.Identifier{schema, name}.Sanitize()
+	if len(o.Columns) > 0 {
+		columns := make([]string, len(o.Columns))
+		for i, c := range o.Columns {
+			columns[i] = pgx.Identifier{c}.Sanitize()
+		}
+		target += " (" + strings.Join(columns, ", ") + ")"
+	}
+	return target
+}
+
+var copyQueryPrefix = regexp.MustCompile(`(?is)^\s*(SELECT|WITH|VALUES|TABLE)\s`)
+
+// CopyIn streams CSV from r into a table (optionally `schema.table`) using the COPY protocol, and returns the number
+// of rows copied.
+// database will default to the primary database
+func (f *fixture) CopyIn(ctx context.Context, database, table string, r io.Reader, opts CSVOptions) (int64, error) {
+	db, err := f.Connect(ctx, ConnOptDatabase(database))
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+
+	tag, err := conn.Conn().PgConn().CopyFrom(ctx, r, fmt.Sprintf("COPY %v FROM STDIN WITH %v", opts.target(table), opts.clause()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy into %v: %w", table, err)
+	}
+	f.log.Debug("copy in", zap.String("database", db.Config().ConnConfig.Database), zap.String("table", table), zap.Int64("rows", tag.RowsAffected()), zap.String("container", f.HostName()))
+	return tag.RowsAffected(), nil
+}
+
+// CopyOut streams a table (optionally `schema.table`) or the result of a query (beginning with SELECT, WITH, VALUES
+// or TABLE) to w as CSV using the COPY protocol, and returns the number of rows copied.
+// database will default to the primary database
+func (f *fixture) CopyOut(ctx context.Context, database, tableOrQuery string, w io.Writer, opts CSVOptions) (int64, error) {
+	source := ""
+	if copyQueryPrefix.MatchString(tableOrQuery) {
+		source = "(" + tableOrQuery + ")"
+	} else {
+		source = opts.target(tableOrQuery)
+	}
+
+	db, err := f.Connect(ctx, ConnOptDatabase(database))
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+
+	tag, err := conn.Conn().PgConn().CopyTo(ctx, w, fmt.Sprintf("COPY %v TO STDOUT WITH %v", source, opts.clause()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy out: %w", err)
+	}
+	f.log.Debug("copy out", zap.String("database", db.Config().ConnConfig.Database), zap.Int64("rows", tag.RowsAffected()), zap.String("container", f.HostName()))
+	return tag.RowsAffected(), nil
+}
+
+// CopyInFile copies a CSV file into a table. See CopyIn.
+func (f *fixture) CopyInFile(ctx context.Context, database, table, path string, opts CSVOptions) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return f.CopyIn(ctx, database, table, file, opts)
+}
+
+// CopyOutFile copies a table or query into a CSV file, creating or truncating it. See CopyOut.
+func (f *fixture) CopyOutFile(ctx context.Context, database, tableOrQuery, path string, opts CSVOptions) (int64, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.CopyOut(ctx, database, tableOrQuery, file, opts)
+	if cerr := file.Close(); err == nil && cerr != nil {
+		return n, cerr
+	}
+	return n, err
+}
@@ -0,0 +1,443 @@
+package pgtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// ReadFixtures reads data fixtures from .yml, .yaml and .json files (or directories of them). Each file maps table
+// names (optionally schema-qualified) to a list of rows, and each row maps column names to values:
+//
+//	person:
+//	  - first_name: Ada
+//	    created_at: "{{ now }}"
+//
+// Rows for the same table in multiple files are concatenated.
+func ReadFixtures(paths ...string) (map[string][]map[string]interface{}, error) {
+	tables := map[string][]map[string]interface{}{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		files := []string{path}
+		if info.IsDir() {
+			files = []string{}
+			for _, pattern := range []string{"*.yml", "*.yaml", "*.json"} {
+				matches, err := filepath.Glob(filepath.Join(path, pattern))
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, matches...)
+			}
+			sort.Strings(files)
+		}
+		for _, file := range files {
+			b, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			data := map[string][]map[string]interface{}{}
+			if filepath.Ext(file) == ".json" {
+				d := json.NewDecoder(bytes.NewReader(b))
+				d.UseNumber()
+				err = d.Decode(&data)
+			} else {
+				err = yaml.Unmarshal(b, &data)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %v: %w", file, err)
+			}
+			for table, rows := range data {
+				schema, name := splitQualifiedName(table)
+				key := schema + "." + name
+				tables[key] = append(tables[key], rows...)
+			}
+		}
+	}
+	return tables, nil
+}
+
+// LoadFixtures inserts the rows found by ReadFixtures using COPY (or INSERT, for types such as composites which can't
+// be copied in binary), in foreign key dependency order, inside a single transaction. Afterwards, sequences owned by the loaded tables are advanced past the highest loaded value.
+//
+// String values are parsed as the column's type (in postgres text format) and may use templates:
+//
+//	{{ now }}            the current time
+//	{{ ago "24h" }}      the current time minus a duration
+//	{{ fromNow "1h" }}   the current time plus a duration
+//	{{ uuid }}           a random UUID
+//
+// database will default to the primary database
+func (f *fixture) LoadFixtures(ctx context.Context, database string, paths ...string) error {
+	tables, err := ReadFixtures(paths...)
+	if err != nil {
+		return err
+	}
+	order, err := f.fixtureOrder(ctx, database, tables)
+	if err != nil {
+		return err
+	}
+
+	db, err := f.Connect(ctx, ConnOptDatabase(database))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	for _, table := range order {
+		schema, name := splitQualifiedName(table)
+		// Rows may specify different columns, and omitted columns must get their defaults rather than NULL,
+		// so copy each distinct set of columns separately.
+		groups := []*fixtureGroup{}
+		groupsByColumns := map[string]*fixtureGroup{}
+		for _, row := range tables[table] {
+			columns := make([]string, 0, len(row))
+			for c := range row {
+				columns = append(columns, c)
+			}
+			sort.Strings(columns)
+			key := fmt.Sprintf("%q", columns)
+			g, ok := groupsByColumns[key]
+			if !ok {
+				g = &fixtureGroup{columns: columns}
+				groupsByColumns[key] = g
+				groups = append(groups, g)
+			}
+			g.rows = append(g.rows, row)
+		}
+		for _, g := range groups {
+			// Empty rows have nothing to copy and are inserted with DEFAULT VALUES.
+			rows, copyable := make([][]interface{}, len(g.rows)), false
+			if len(g.columns) > 0 {
+				if rows, copyable, err = fixtureRows(ctx, tx.Conn(), schema, name, g.columns, g.rows, now); err != nil {
+					return fmt.Errorf("failed to load %v: %w", table, err)
+				}
+			}
+			if copyable {
+				_, err = tx.CopyFrom(ctx, pgx.Identifier{schema, name}, g.columns, pgx.CopyFromRows(rows))
+			} else {
+				err = insertFixtureRows(ctx, tx, schema, name, g.columns, rows)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to load %v: %w", table, err)
+			}
+		}
+	}
+
+	sequences, err := f.Sequences(ctx, database)
+	if err != nil {
+		return err
+	}
+	for _, seq := range sequences {
+		parts := strings.Split(seq.OwnedBy, ".")
+		if len(parts) != 3 {
+			continue
+		}
+		if _, ok := tables[parts[0]+"."+parts[1]]; !ok {
+			continue
+		}
+		query := fmt.Sprintf("SELECT setval($1::regclass, max(%v)) FROM %v HAVING max(%v) IS NOT NULL",
+			pgx.Identifier{parts[2]}.Sanitize(),
+			pgx.Identifier{parts[0], parts[1]}.Sanitize(),
+			pgx.Identifier{parts[2]}.Sanitize(),
+		)
+		if _, err := tx.Exec(ctx, query, pgx.Identifier{seq.Schema, seq.Name}.Sanitize()); err != nil {
+			return fmt.Errorf("failed to reset sequence %v.%v: %w", seq.Schema, seq.Name, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	f.log.Debug("load fixtures", zap.String("database", db.Config().ConnConfig.Database), zap.Strings("tables", order), zap.String("container", f.HostName()))
+	return nil
+}
+
+// fixtureGroup is the rows of a table which specify the same columns.
+type fixtureGroup struct {
+	columns []string
+	rows    []map[string]interface{}
+}
+
+// insertFixtureRows inserts rows one at a time, for values which can't be sent with binary COPY, or for rows without
+// any columns.
+func insertFixtureRows(ctx context.Context, tx pgx.Tx, schema, table string, columns []string, rows [][]interface{}) error {
+	identifiers := make([]string, len(columns))
+	params := make([]string, len(columns))
+	for i, c := range columns {
+		identifiers[i] = pgx.Identifier{c}.Sanitize()
+		params[i] = fmt.Sprintf("$%v", i+1)
+	}
+	query := fmt.Sprintf("INSERT INTO %v DEFAULT VALUES", pgx.Identifier{schema, table}.Sanitize())
+	if len(columns) > 0 {
+		query = fmt.Sprintf("INSERT INTO %v (%v) VALUES (%v)", pgx.Identifier{schema, table}.Sanitize(), strings.Join(identifiers, ", "), strings.Join(params, ", "))
+	}
+	for _, row := range rows {
+		if _, err := tx.Exec(ctx, query, row...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fixtureOrder sorts tables so that every table comes after the tables its foreign keys reference.
+func (f *fixture) fixtureOrder(ctx context.Context, database string, tables map[string][]map[string]interface{}) ([]string, error) {
+	constraints, err := f.Constraints(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+	dependsOn := map[string][]string{}
+	for _, c := range constraints {
+		table := c.Schema + "." + c.Table
+		if c.References == "" || c.References == table {
+			continue
+		}
+		if _, ok := tables[c.References]; ok {
+			dependsOn[table] = append(dependsOn[table], c.References)
+		}
+	}
+
+	names := make([]string, 0, len(tables))
+	for t := range tables {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+
+	order := []string{}
+	state := map[string]int{} // 1: visiting, 2: done
+	var visit func(t string, path []string) error
+	visit = func(t string, path []string) error {
+		switch state[t] {
+		case 1:
+			return fmt.Errorf("foreign keys form a cycle: %v", strings.Join(append(path, t), " -> "))
+		case 2:
+			return nil
+		}
+		state[t] = 1
+		deps := dependsOn[t]
+		sort.Strings(deps)
+		for _, d := range deps {
+			if err := visit(d, append(path, t)); err != nil {
+				return err
+			}
+		}
+		state[t] = 2
+		order = append(order, t)
+		return nil
+	}
+	for _, t := range names {
+		if err := visit(t, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// fixtureRows converts fixture values to the types of the table's columns. copyable is false if any column has a type
+// which pgx can't encode in binary (e.g. a composite or an array of enums), in which case those values are left as
+// text, and the rows must be inserted rather than copied.
+func fixtureRows(ctx context.Context, conn *pgx.Conn, schema, table string, columns []string, rows []map[string]interface{}, now time.Time) (out [][]interface{}, copyable bool, err error) {
+	identifiers := make([]string, len(columns))
+	for i, c := range columns {
+		identifiers[i] = pgx.Identifier{c}.Sanitize()
+	}
+	sd, err := conn.Prepare(ctx, "", fmt.Sprintf("SELECT %v FROM %v", strings.Join(identifiers, ", "), pgx.Identifier{schema, table}.Sanitize()))
+	if err != nil {
+		return nil, false, err
+	}
+	ci := conn.ConnInfo()
+	copyable = true
+	types := make([]*pgtype.DataType, len(sd.Fields))
+	names := make([]string, len(sd.Fields))
+	for i, fd := range sd.Fields {
+		dt, ok := ci.DataTypeForOID(fd.DataTypeOID)
+		if !ok {
+			if dt, err = registerFixtureType(ctx, conn, fd.DataTypeOID); err != nil {
+				return nil, false, err
+			}
+		}
+		if dt == nil {
+			copyable = false
+			if err := conn.QueryRow(ctx, "SELECT typname::text FROM pg_catalog.pg_type WHERE oid = $1", fd.DataTypeOID).Scan(&names[i]); err != nil {
+				return nil, false, err
+			}
+		} else {
+			names[i] = dt.Name
+		}
+		types[i] = dt
+	}
+
+	out = make([][]interface{}, len(rows))
+	for r, row := range rows {
+		values := make([]interface{}, len(columns))
+		for i, c := range columns {
+			v, err := fixtureValue(ci, types[i], names[i], row[c], now)
+			if err != nil {
+				return nil, false, fmt.Errorf("row %v, column %v: %w", r, c, err)
+			}
+			values[i] = v
+		}
+		out[r] = values
+	}
+	return out, copyable, nil
+}
+
+// registerFixtureType teaches pgx a type it doesn't know, if it can be encoded in binary: domains are encoded as their
+// base type, and enums (whose binary representation is their label) as text. Returns nil for other types.
+func registerFixtureType(ctx context.Context, conn *pgx.Conn, oid uint32) (*pgtype.DataType, error) {
+	ci := conn.ConnInfo()
+	var name string
+	for base := oid; ; {
+		var typtype string
+		var baseName string
+		var next uint32
+		if err := conn.QueryRow(ctx, "SELECT typname::text, typtype::text, typbasetype FROM pg_catalog.pg_type WHERE oid = $1", base).Scan(&baseName, &typtype, &next); err != nil {
+			return nil, err
+		}
+		if name == "" {
+			name = baseName
+		}
+		var value pgtype.Value
+		if dt, ok := ci.DataTypeForOID(base); ok {
+			value = pgtype.NewValue(dt.Value)
+		} else if typtype == "e" {
+			value = &pgtype.Text{}
+		} else if typtype == "d" {
+			base = next
+			continue
+		} else {
+			return nil, nil
+		}
+		ci.RegisterDataType(pgtype.DataType{Value: value, Name: name, OID: oid})
+		dt, _ := ci.DataTypeForOID(oid)
+		return dt, nil
+	}
+}
+
+// fixtureValue converts a fixture value for a column of type typeName. If dt is nil, the value is rendered as text.
+func fixtureValue(ci *pgtype.ConnInfo, dt *pgtype.DataType, typeName string, v interface{}, now time.Time) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if dt == nil {
+		s, ok := v.(string)
+		if !ok {
+			s = textLiteral(v)
+		}
+		return renderFixtureTemplate(s, typeName, now)
+	}
+	value := pgtype.NewValue(dt.Value)
+	s, ok := v.(string)
+	if !ok {
+		if err := value.Set(v); err == nil {
+			return value, nil
+		}
+		s = fmt.Sprint(v)
+	}
+	s, err := renderFixtureTemplate(s, typeName, now)
+	if err != nil {
+		return nil, err
+	}
+	decoder, ok := value.(pgtype.TextDecoder)
+	if !ok {
+		return s, nil
+	}
+	if err := decoder.DecodeText(ci, []byte(s)); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// textLiteral formats a fixture value in postgres text format. Lists (of lists) become array literals.
+func textLiteral(v interface{}) string {
+	list, ok := v.([]interface{})
+	if !ok {
+		return fmt.Sprint(v)
+	}
+	elements := make([]string, len(list))
+	for i, e := range list {
+		if e == nil {
+			elements[i] = "NULL"
+			continue
+		}
+		if _, ok := e.([]interface{}); ok {
+			elements[i] = textLiteral(e)
+			continue
+		}
+		s := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(textLiteral(e))
+		elements[i] = `"` + s + `"`
+	}
+	return "{" + strings.Join(elements, ",") + "}"
+}
+
+// templateTime formats itself in the layout of the column it's being rendered for.
+type templateTime struct {
+	time.Time
+	layout string
+}
+
+func (t templateTime) String() string {
+	return t.Format(t.layout)
+}
+
+func renderFixtureTemplate(s, typeName string, now time.Time) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	layout := "2006-01-02 15:04:05.999999999Z07:00"
+	switch typeName {
+	case "timestamp":
+		layout = "2006-01-02 15:04:05.999999999"
+	case "date":
+		layout = "2006-01-02"
+	}
+	offset := func(sign time.Duration) func(string) (templateTime, error) {
+		return func(d string) (templateTime, error) {
+			duration, err := time.ParseDuration(d)
+			if err != nil {
+				return templateTime{}, err
+			}
+			return templateTime{now.Add(sign * duration), layout}, nil
+		}
+	}
+	tmpl, err := template.New("").Funcs(template.FuncMap{
+		"now":     func() templateTime { return templateTime{now, layout} },
+		"ago":     offset(-1),
+		"fromNow": offset(1),
+		"uuid":    func() string { return uuid.NewString() },
+	}).Parse(s)
+	if err != nil {
+		return "", err
+	}
+	b := strings.Builder{}
+	if err := tmpl.Execute(&b, nil); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
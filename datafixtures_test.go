@@ -0,0 +1,47 @@
+package pgtest
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFixtures(t *testing.T) {
+	tables, err := ReadFixtures("testdata/fixtures")
+	require.NoError(t, err)
+	require.Len(t, tables["public.address"], 2)
+	require.Len(t, tables["public.person"], 2)
+	assert.Equal(t, json.Number("1"), tables["public.address"][0]["id"])
+	assert.Equal(t, 10, tables["public.person"][1]["id"])
+
+	tables, err = ReadFixtures("testdata/typed")
+	require.NoError(t, err)
+	require.Len(t, tables["public.tally"], 2)
+	assert.Empty(t, tables["public.tally"][0])
+	assert.Equal(t, "counted", tables["public.tally"][1]["label,text"])
+}
+
+func TestRenderFixtureTemplate(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for typeName, expected := range map[string]string{
+		"timestamptz": "2020-01-01 03:04:05Z",
+		"timestamp":   "2020-01-01 03:04:05",
+		"date":        "2020-01-01",
+	} {
+		s, err := renderFixtureTemplate(`{{ ago "24h" }}`, typeName, now)
+		require.NoError(t, err)
+		assert.Equal(t, expected, s)
+	}
+	s, err := renderFixtureTemplate("plain", "text", now)
+	require.NoError(t, err)
+	assert.Equal(t, "plain", s)
+}
+
+func TestTextLiteral(t *testing.T) {
+	assert.Equal(t, "3", textLiteral(3))
+	assert.Equal(t, `{"a","b \"c\"",NULL}`, textLiteral([]interface{}{"a", `b "c"`, nil}))
+	assert.Equal(t, `{{"1","2"},{"3","4"}}`, textLiteral([]interface{}{[]interface{}{1, 2}, []interface{}{3, 4}}))
+}
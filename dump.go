@@ -0,0 +1,420 @@
+package pgtest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charlieparkes/go-fixtures/v2"
+	"github.com/ory/dockertest/v3/docker"
+	"go.uber.org/zap"
+)
+
+type DumpFormat string
+
+const (
+	// DumpFormatCustom is pg_dump's compressed archive format, restored with pg_restore. This is the default.
+	DumpFormatCustom DumpFormat = "custom"
+	// DumpFormatPlain is a plain SQL script, restored with psql.
+	DumpFormatPlain DumpFormat = "plain"
+	// DumpFormatDirectory is a directory with one file per table, which pg_restore can restore in parallel.
+	DumpFormatDirectory DumpFormat = "directory"
+	// DumpFormatTar is an uncompressed tar archive, restored with pg_restore.
+	DumpFormatTar DumpFormat = "tar"
+)
+
+type dumpConfig struct {
+	database       string
+	format         DumpFormat
+	compression    int
+	schemaOnly     bool
+	dataOnly       bool
+	tables         []string
+	excludeTables  []string
+	schemas        []string
+	excludeSchemas []string
+}
+
+type DumpOpt func(*dumpConfig)
+
+// DumpOptDatabase dumps a database other than the primary database, e.g. one created by CopyDatabase.
+func DumpOptDatabase(name string) DumpOpt {
+	return func(c *dumpConfig) {
+		c.database = name
+	}
+}
+
+// DumpOptFormat sets the archive format. Defaults to DumpFormatCustom.
+func DumpOptFormat(format DumpFormat) DumpOpt {
+	return func(c *dumpConfig) {
+		c.format = format
+	}
+}
+
+// DumpOptCompression sets the compression level, from 0 to 9. Defaults to 0. Ignored for DumpFormatTar.
+func DumpOptCompression(level int) DumpOpt {
+	return func(c *dumpConfig) {
+		c.compression = level
+	}
+}
+
+// DumpOptSchemaOnly dumps object definitions but no data.
+func DumpOptSchemaOnly() DumpOpt {
+	return func(c *dumpConfig) {
+		c.schemaOnly = true
+	}
+}
+
+// DumpOptDataOnly dumps data but no object definitions.
+func DumpOptDataOnly() DumpOpt {
+	return func(c *dumpConfig) {
+		c.dataOnly = true
+	}
+}
+
+// DumpOptTables only dumps tables matching these pg_dump patterns (e.g. `public.person` or `audit.*`).
+func DumpOptTables(patterns ...string) DumpOpt {
+	return func(c *dumpConfig) {
+		c.tables = append(c.tables, patterns...)
+	}
+}
+
+// DumpOptExcludeTables skips tables matching these pg_dump patterns.
+func DumpOptExcludeTables(patterns ...string) DumpOpt {
+	return func(c *dumpConfig) {
+		c.excludeTables = append(c.excludeTables, patterns...)
+	}
+}
+
+// DumpOptSchemas only dumps schemas matching these pg_dump patterns.
+func DumpOptSchemas(patterns ...string) DumpOpt {
+	return func(c *dumpConfig) {
+		c.schemas = append(c.schemas, patterns...)
+	}
+}
+
+// DumpOptExcludeSchemas skips schemas matching these pg_dump patterns.
+func DumpOptExcludeSchemas(patterns ...string) DumpOpt {
+	return func(c *dumpConfig) {
+		c.excludeSchemas = append(c.excludeSchemas, patterns...)
+	}
+}
+
+func newDumpConfig(opts []DumpOpt) (*dumpConfig, error) {
+	cfg := &dumpConfig{format: DumpFormatCustom}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	switch cfg.format {
+	case DumpFormatCustom, DumpFormatPlain, DumpFormatDirectory, DumpFormatTar:
+	default:
+		return nil, fmt.Errorf("unknown dump format: %v", cfg.format)
+	}
+	if cfg.schemaOnly && cfg.dataOnly {
+		return nil, errors.New("cannot dump schema only and data only")
+	}
+	if cfg.compression < 0 || cfg.compression > 9 {
+		return nil, fmt.Errorf("invalid compression level: %v", cfg.compression)
+	}
+	return cfg, nil
+}
+
+// args returns the pg_dump arguments, excluding the output file and database name.
+func (c *dumpConfig) args() []string {
+	args := []string{"--format=" + string(c.format)}
+	if c.format != DumpFormatTar {
+		args = append(args, fmt.Sprintf("--compress=%v", c.compression))
+	}
+	if c.schemaOnly {
+		args = append(args, "--schema-only")
+	}
+	if c.dataOnly {
+		args = append(args, "--data-only")
+	}
+	for _, t := range c.tables {
+		args = append(args, "--table="+t)
+	}
+	for _, t := range c.excludeTables {
+		args = append(args, "--exclude-table="+t)
+	}
+	for _, s := range c.schemas {
+		args = append(args, "--schema="+s)
+	}
+	for _, s := range c.excludeSchemas {
+		args = append(args, "--exclude-schema="+s)
+	}
+	return args
+}
+
+// Dump runs pg_dump, writing dir/filename. With DumpFormatDirectory, filename is the name of the directory created.
+func (f *fixture) Dump(ctx context.Context, dir string, filename string, opts ...DumpOpt) error {
+	path := fixtures.FindPath(dir)
+	if path == "" {
+		return fmt.Errorf("could not resolve path: %v", dir)
+	}
+	cfg, err := newDumpConfig(opts)
+	if err != nil {
+		return err
+	}
+	database := cfg.database
+	if database == "" {
+		database = f.settings.Database
+	}
+	cmd := append([]string{"pg_dump"}, cfg.args()...)
+	cmd = append(cmd, "--file=/tmp/"+filename, database)
+	exitCode, err := f.Psql(ctx, cmd, []string{fmt.Sprintf("%v:/tmp", path)}, false)
+	f.log.Debug("dump database", zap.Int("status", exitCode), zap.String("database", database), zap.String("format", string(cfg.format)), zap.String("container", f.HostName()), zap.String("path", path))
+	return err
+}
+
+type restoreConfig struct {
+	database string
+	clean    bool
+	noOwner  bool
+	jobs     int
+}
+
+type RestoreOpt func(*restoreConfig)
+
+// RestoreOptDatabase restores into a database other than the primary database, creating it if it doesn't exist.
+func RestoreOptDatabase(name string) RestoreOpt {
+	return func(c *restoreConfig) {
+		c.database = name
+	}
+}
+
+// RestoreOptClean drops objects before recreating them (`--clean --if-exists`), so a dump can be restored over
+// a database which already contains them.
+func RestoreOptClean() RestoreOpt {
+	return func(c *restoreConfig) {
+		c.clean = true
+	}
+}
+
+// RestoreOptNoOwner skips restoring object ownership, so dumps from other roles can be restored.
+func RestoreOptNoOwner() RestoreOpt {
+	return func(c *restoreConfig) {
+		c.noOwner = true
+	}
+}
+
+// RestoreOptJobs restores with n parallel jobs. Requires a custom or directory format dump, and, since parallel
+// jobs can't share a transaction, the restore is no longer atomic.
+func RestoreOptJobs(n int) RestoreOpt {
+	return func(c *restoreConfig) {
+		c.jobs = n
+	}
+}
+
+func newRestoreConfig(opts []RestoreOpt) *restoreConfig {
+	cfg := &restoreConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// command returns the command which restores a dump of the given format from path into database. If path is empty,
+// the dump is read from stdin.
+func (c *restoreConfig) command(format DumpFormat, database, path string) ([]string, error) {
+	if format == DumpFormatPlain {
+		switch {
+		case c.clean:
+			return nil, errors.New("cannot clean when restoring a plain dump; dump with --clean instead")
+		case c.noOwner:
+			return nil, errors.New("cannot skip ownership when restoring a plain dump; dump with --no-owner instead")
+		case c.jobs > 1:
+			return nil, errors.New("cannot restore a plain dump with parallel jobs")
+		}
+		if path == "" {
+			path = "-"
+		}
+		return []string{"psql", "--dbname=" + database, "--single-transaction", "--set=ON_ERROR_STOP=1", "--quiet", "--file=" + path}, nil
+	}
+	cmd := []string{"pg_restore", "--dbname=" + database, "--verbose"}
+	if c.jobs > 1 {
+		cmd = append(cmd, fmt.Sprintf("--jobs=%v", c.jobs))
+	} else {
+		cmd = append(cmd, "--single-transaction")
+	}
+	if c.clean {
+		cmd = append(cmd, "--clean", "--if-exists")
+	}
+	if c.noOwner {
+		cmd = append(cmd, "--no-owner")
+	}
+	if path == "" {
+		if c.jobs > 1 {
+			return nil, errors.New("cannot restore from a stream with parallel jobs")
+		}
+		return cmd, nil
+	}
+	return append(cmd, path), nil
+}
+
+// detectDumpFormat identifies a dump from its first bytes: custom archives start with "PGDMP", tar archives have
+// the "ustar" magic at offset 257, and anything else is assumed to be SQL.
+func detectDumpFormat(header []byte) DumpFormat {
+	switch {
+	case bytes.HasPrefix(header, []byte("PGDMP")):
+		return DumpFormatCustom
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return DumpFormatTar
+	}
+	return DumpFormatPlain
+}
+
+// readDumpFormat identifies the dump at path, which may be a directory format dump.
+func readDumpFormat(path string) (DumpFormat, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return DumpFormatDirectory, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return detectDumpFormat(header[:n]), nil
+}
+
+// Restore restores dir/filename, which may be any format written by Dump. Plain SQL dumps are run with psql, and
+// other formats with pg_restore. By default, the dump is restored into the primary database in a single transaction.
+func (f *fixture) Restore(ctx context.Context, dir string, filename string, opts ...RestoreOpt) error {
+	path := fixtures.FindPath(dir)
+	if path == "" {
+		return fmt.Errorf("could not resolve path: %v", dir)
+	}
+	cfg := newRestoreConfig(opts)
+	format, err := readDumpFormat(filepath.Join(path, filename))
+	if err != nil {
+		return err
+	}
+	database, err := f.restoreDatabase(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	cmd, err := cfg.command(format, database, "/tmp/"+filename)
+	if err != nil {
+		return err
+	}
+	exitCode, err := f.Psql(ctx, cmd, []string{fmt.Sprintf("%v:/tmp", path)}, false)
+	f.log.Debug("restore database", zap.Int("status", exitCode), zap.String("database", database), zap.String("format", string(format)), zap.String("container", f.HostName()), zap.String("path", path))
+	return err
+}
+
+// restoreDatabase returns the database to restore into, creating it if necessary.
+func (f *fixture) restoreDatabase(ctx context.Context, cfg *restoreConfig) (string, error) {
+	if cfg.database == "" || cfg.database == f.settings.Database {
+		return f.settings.Database, nil
+	}
+	exists, err := f.DatabaseExists(ctx, cfg.database)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if err := f.CreateDatabase(ctx, cfg.database); err != nil {
+			return "", err
+		}
+	}
+	return cfg.database, nil
+}
+
+// DumpTo runs pg_dump inside the postgres container and streams the dump to w, so dumps don't need a bind-mountable
+// directory. DumpFormatDirectory is not supported.
+func (f *fixture) DumpTo(ctx context.Context, w io.Writer, opts ...DumpOpt) error {
+	cfg, err := newDumpConfig(opts)
+	if err != nil {
+		return err
+	}
+	if cfg.format == DumpFormatDirectory {
+		return errors.New("cannot stream a directory format dump")
+	}
+	database := cfg.database
+	if database == "" {
+		database = f.settings.Database
+	}
+	cmd := append([]string{"pg_dump"}, cfg.args()...)
+	cmd = append(cmd, database)
+	exitCode, err := f.exec(ctx, cmd, nil, w)
+	f.log.Debug("dump database", zap.Int("status", exitCode), zap.String("database", database), zap.String("format", string(cfg.format)), zap.String("container", f.HostName()))
+	return err
+}
+
+// RestoreFrom streams a dump written by DumpTo (or Dump) from r into pg_restore or psql inside the postgres container,
+// depending on its format. See Restore.
+func (f *fixture) RestoreFrom(ctx context.Context, r io.Reader, opts ...RestoreOpt) error {
+	cfg := newRestoreConfig(opts)
+	br := bufio.NewReaderSize(r, 512)
+	header, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	format := detectDumpFormat(header)
+	database, err := f.restoreDatabase(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	cmd, err := cfg.command(format, database, "")
+	if err != nil {
+		return err
+	}
+	exitCode, err := f.exec(ctx, cmd, br, io.Discard)
+	f.log.Debug("restore database", zap.Int("status", exitCode), zap.String("database", database), zap.String("format", string(format)), zap.String("container", f.HostName()))
+	return err
+}
+
+// exec runs a command inside the postgres container, connecting over its local socket. Cancelling ctx stops streaming
+// and returns ctx.Err(), but docker has no way to kill an exec, so the command itself may run until it finishes.
+func (f *fixture) exec(ctx context.Context, cmd []string, stdin io.Reader, stdout io.Writer) (int, error) {
+	client := f.docker.Pool().Client
+	e, err := client.CreateExec(docker.CreateExecOptions{
+		Container: f.resource.Container.ID,
+		Cmd:       cmd,
+		Env: []string{
+			"PGUSER=" + f.settings.User,
+			"PGPASSWORD=" + f.settings.Password,
+		},
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Context:      ctx,
+	})
+	if err != nil {
+		return -1, fmt.Errorf("failed to create exec: %w", err)
+	}
+	stderr := bytes.Buffer{}
+	if err := client.StartExec(e.ID, docker.StartExecOptions{
+		InputStream:  stdin,
+		OutputStream: stdout,
+		ErrorStream:  &stderr,
+		Context:      ctx,
+	}); err != nil {
+		if ctx.Err() != nil {
+			return -1, ctx.Err()
+		}
+		return -1, fmt.Errorf("failed to start exec: %w", err)
+	}
+	inspect, err := client.InspectExec(e.ID)
+	if err != nil {
+		return -1, fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	if inspect.ExitCode != 0 {
+		return inspect.ExitCode, fmt.Errorf("%v exited with error (code: %v): %v", cmd[0], inspect.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+	return inspect.ExitCode, nil
+}
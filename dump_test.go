@@ -0,0 +1,81 @@
+package pgtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpConfig(t *testing.T) {
+	cfg, err := newDumpConfig(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--format=custom", "--compress=0"}, cfg.args())
+
+	cfg, err = newDumpConfig([]DumpOpt{
+		DumpOptFormat(DumpFormatPlain),
+		DumpOptSchemaOnly(),
+		DumpOptTables("public.person", "public.address"),
+		DumpOptExcludeSchemas("audit"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"--format=plain",
+		"--compress=0",
+		"--schema-only",
+		"--table=public.person",
+		"--table=public.address",
+		"--exclude-schema=audit",
+	}, cfg.args())
+
+	cfg, err = newDumpConfig([]DumpOpt{DumpOptFormat(DumpFormatTar), DumpOptCompression(9), DumpOptDataOnly()})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--format=tar", "--data-only"}, cfg.args())
+
+	_, err = newDumpConfig([]DumpOpt{DumpOptSchemaOnly(), DumpOptDataOnly()})
+	assert.Error(t, err)
+	_, err = newDumpConfig([]DumpOpt{DumpOptFormat("zip")})
+	assert.Error(t, err)
+	_, err = newDumpConfig([]DumpOpt{DumpOptCompression(10)})
+	assert.Error(t, err)
+}
+
+func TestDetectDumpFormat(t *testing.T) {
+	assert.Equal(t, DumpFormatCustom, detectDumpFormat([]byte("PGDMP\x01\x0e\x00")))
+	tar := make([]byte, 512)
+	copy(tar[257:], "ustar\x0000")
+	assert.Equal(t, DumpFormatTar, detectDumpFormat(tar))
+	assert.Equal(t, DumpFormatPlain, detectDumpFormat([]byte("--\n-- PostgreSQL database dump\n--\n")))
+	assert.Equal(t, DumpFormatPlain, detectDumpFormat(nil))
+}
+
+func TestRestoreCommand(t *testing.T) {
+	cmd, err := newRestoreConfig(nil).command(DumpFormatCustom, "db", "/tmp/test.pgdump")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pg_restore", "--dbname=db", "--verbose", "--single-transaction", "/tmp/test.pgdump"}, cmd)
+
+	cmd, err = newRestoreConfig([]RestoreOpt{RestoreOptClean(), RestoreOptNoOwner(), RestoreOptJobs(4)}).command(DumpFormatDirectory, "db", "/tmp/test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pg_restore", "--dbname=db", "--verbose", "--jobs=4", "--clean", "--if-exists", "--no-owner", "/tmp/test"}, cmd)
+
+	cmd, err = newRestoreConfig(nil).command(DumpFormatPlain, "db", "/tmp/test.sql")
+	require.NoError(t, err)
+	assert.Equal(t, "psql", cmd[0])
+	assert.Contains(t, cmd, "--file=/tmp/test.sql")
+
+	_, err = newRestoreConfig([]RestoreOpt{RestoreOptClean()}).command(DumpFormatPlain, "db", "/tmp/test.sql")
+	assert.Error(t, err)
+}
+
+func TestRestoreCommandStdin(t *testing.T) {
+	cmd, err := newRestoreConfig(nil).command(DumpFormatCustom, "db", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pg_restore", "--dbname=db", "--verbose", "--single-transaction"}, cmd)
+
+	cmd, err = newRestoreConfig(nil).command(DumpFormatPlain, "db", "")
+	require.NoError(t, err)
+	assert.Contains(t, cmd, "--file=-")
+
+	_, err = newRestoreConfig([]RestoreOpt{RestoreOptJobs(2)}).command(DumpFormatCustom, "db", "")
+	assert.Error(t, err)
+}
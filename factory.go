@@ -0,0 +1,236 @@
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Insert inserts structs into the table they map to (see ValidateModel for how tables and columns are named) and
+// returns them as scanned back from `RETURNING *`, so database defaults such as serial ids are populated.
+// Zero-valued fields are omitted for identity and serial columns, so the database assigns them, and generated columns
+// are never inserted. Every other field is inserted as is, even if its column has a default; see InsertOptOmitZero.
+func Insert[T any](ctx context.Context, db Queryer, rows ...T) ([]T, error) {
+	return InsertWithOpts(ctx, db, rows)
+}
+
+type insertConfig struct {
+	omit map[string]bool
+}
+
+type InsertOpt func(*insertConfig)
+
+// InsertOptOmitZero leaves columns to their database defaults when their fields are zero-valued, e.g. a created_at
+// timestamp which defaults to now().
+func InsertOptOmitZero(columns ...string) InsertOpt {
+	return func(c *insertConfig) {
+		if c.omit == nil {
+			c.omit = map[string]bool{}
+		}
+		for _, column := range columns {
+			c.omit[column] = true
+		}
+	}
+}
+
+// InsertWithOpts is like Insert, with options.
+func InsertWithOpts[T any](ctx context.Context, db Queryer, rows []T, opts ...InsertOpt) ([]T, error) {
+	cfg := &insertConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return insert(ctx, db, newValidateConfig(nil), cfg.omit, rows)
+}
+
+// omitZero reports whether a zero-valued field should be left for the database to fill in.
+func omitZero(c Column, omit map[string]bool) bool {
+	return c.IsIdentity || strings.HasPrefix(c.Default, "nextval(") || omit[c.Name]
+}
+
+func insert[T any](ctx context.Context, db Queryer, cfg *validateConfig, omit map[string]bool, rows []T) ([]T, error) {
+	var zero T
+	t := reflect.TypeOf(&zero).Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot insert %T: not a struct", zero)
+	}
+	schema, table := modelTable(reflect.New(t).Interface(), cfg)
+	fields := modelFields(reflect.New(t).Interface(), cfg)
+
+	columns, err := tableColumns(ctx, db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %v.%v does not exist", schema, table)
+	}
+	columnsByName := make(map[string]Column, len(columns))
+	for _, c := range columns {
+		columnsByName[c.Name] = c
+	}
+	fieldsByColumn := make(map[string]modelField, len(fields))
+	for _, field := range fields {
+		if _, ok := columnsByName[field.Column]; !ok {
+			return nil, fmt.Errorf("struct %v contains field %v which does not exist in table %v.%v", t.Name(), field.Name, schema, table)
+		}
+		fieldsByColumn[field.Column] = field
+	}
+
+	out := make([]T, len(rows))
+	for r, row := range rows {
+		v := reflect.ValueOf(&row).Elem()
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		for v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+
+		names := []string{}
+		params := []string{}
+		values := []interface{}{}
+		for _, field := range fields {
+			c := columnsByName[field.Column]
+			fv, ok := existingFieldByIndex(v, field.Index)
+			if c.IsGenerated || !ok || fv.IsZero() && omitZero(c, omit) {
+				continue
+			}
+			names = append(names, pgx.Identifier{c.Name}.Sanitize())
+			values = append(values, fv.Interface())
+			params = append(params, fmt.Sprintf("$%v", len(values)))
+		}
+		query := fmt.Sprintf("INSERT INTO %v DEFAULT VALUES RETURNING *", pgx.Identifier{schema, table}.Sanitize())
+		if len(names) > 0 {
+			query = fmt.Sprintf("INSERT INTO %v (%v) VALUES (%v) RETURNING *", pgx.Identifier{schema, table}.Sanitize(), strings.Join(names, ", "), strings.Join(params, ", "))
+		}
+
+		result, err := db.Query(ctx, query, values...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert into %v.%v: %w", schema, table, err)
+		}
+		if !result.Next() {
+			result.Close()
+			if err := result.Err(); err != nil {
+				return nil, fmt.Errorf("failed to insert into %v.%v: %w", schema, table, err)
+			}
+			return nil, fmt.Errorf("failed to insert into %v.%v: no row returned", schema, table)
+		}
+		dest := []interface{}{}
+		for _, fd := range result.FieldDescriptions() {
+			field, ok := fieldsByColumn[string(fd.Name)]
+			if !ok {
+				dest = append(dest, new(interface{}))
+				continue
+			}
+			dest = append(dest, allocFieldByIndex(v, field.Index).Addr().Interface())
+		}
+		err = result.Scan(dest...)
+		result.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %v.%v: %w", schema, table, err)
+		}
+		out[r] = row
+	}
+	return out, nil
+}
+
+// existingFieldByIndex is like reflect.Value.FieldByIndex, but reports false rather than panicking when the path
+// passes through a nil embedded pointer.
+func existingFieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// allocFieldByIndex is like reflect.Value.FieldByIndex, but allocates nil embedded pointers along the way.
+func allocFieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// Factory builds and inserts test rows from a function which supplies default values.
+//
+//	users := pgtest.NewFactory(pool, func(n int) User {
+//		return User{Email: fmt.Sprintf("user%v@example.com", n), Name: "Test User"}
+//	})
+//	admin, err := users.Create(ctx, func(u *User) { u.Admin = true })
+type Factory[T any] struct {
+	db       Queryer
+	defaults func(n int) T
+	cfg      *validateConfig
+	omit     map[string]bool
+	seq      int64
+}
+
+// NewFactory returns a Factory which inserts into db. defaults is called with a sequence number, unique to the
+// factory and starting from 1, which can be used to generate unique values. ValidateOptTagName and ValidateOptNamer
+// control how the table and columns are named.
+func NewFactory[T any](db Queryer, defaults func(n int) T, opts ...ValidateOpt) *Factory[T] {
+	return &Factory[T]{
+		db:       db,
+		defaults: defaults,
+		cfg:      newValidateConfig(opts),
+	}
+}
+
+// OmitZero is like InsertOptOmitZero. Otherwise, zero values are inserted (so overrides such as `u.Active = false`
+// apply), except for identity and serial columns.
+func (f *Factory[T]) OmitZero(columns ...string) *Factory[T] {
+	cfg := &insertConfig{omit: f.omit}
+	InsertOptOmitZero(columns...)(cfg)
+	f.omit = cfg.omit
+	return f
+}
+
+// Build returns a row with defaults and overrides applied, without inserting it.
+func (f *Factory[T]) Build(overrides ...func(*T)) T {
+	n := int(atomic.AddInt64(&f.seq, 1))
+	var row T
+	if f.defaults != nil {
+		row = f.defaults(n)
+	}
+	for _, override := range overrides {
+		override(&row)
+	}
+	return row
+}
+
+// Create builds and inserts a row, returning it as scanned back from the database.
+func (f *Factory[T]) Create(ctx context.Context, overrides ...func(*T)) (T, error) {
+	rows, err := f.CreateN(ctx, 1, overrides...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return rows[0], nil
+}
+
+// CreateN builds and inserts n rows, applying the same overrides to each.
+func (f *Factory[T]) CreateN(ctx context.Context, n int, overrides ...func(*T)) ([]T, error) {
+	rows := make([]T, n)
+	for i := range rows {
+		rows[i] = f.Build(overrides...)
+	}
+	return insert(ctx, f.db, f.cfg, f.omit, rows)
+}
@@ -10,9 +10,12 @@ import (
 	"time"
 
 	"github.com/charlieparkes/go-fixtures/v2"
-	"github.com/jackc/pgtype"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgconn/stmtcache"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
 	"go.uber.org/zap"
 )
 
@@ -21,6 +24,8 @@ const (
 	DEFAULT_POSTGRES_VERSION = "13-alpine"
 )
 
+const tmpfsPGDATA = "/var/lib/postgresql/tmpfs"
+
 type fixture struct {
 	fixtures.BaseFixture
 	log          *zap.Logger
@@ -34,13 +39,21 @@ type fixture struct {
 	timeoutAfter uint
 	skipTearDown bool
 	mounts       []string
+	pgdata       string
+	tmpfsMB      int
+	memoryMB     int
+	budgetMB     int
+	parallelism  int
+	runOptions   []func(*dockertest.RunOptions)
+	hostConfig   []func(*docker.HostConfig)
 }
 
 func (f *fixture) Settings() *ConnectionSettings {
 	return f.settings
 }
 
-func (f *fixture) SetUp(ctx context.Context) error {
+// defaults fills in unset options. It's idempotent, so it may be called before SetUp.
+func (f *fixture) defaults() {
 	if f.log == nil {
 		f.log = zap.Must(zap.NewDevelopment())
 	}
@@ -54,18 +67,27 @@ func (f *fixture) SetUp(ctx context.Context) error {
 		f.name = "postgres"
 	}
 	if f.settings == nil {
+		password := fixtures.GenerateString()
+		if f.pgdata == committablePGDATA {
+			password = committedPassword
+		}
 		f.settings = &ConnectionSettings{
 			User:       "postgres",
-			Password:   fixtures.GenerateString(),
+			Password:   password,
 			Database:   f.name,
 			DisableSSL: true,
 		}
 	}
+}
+
+func (f *fixture) SetUp(ctx context.Context) error {
+	f.defaults()
 
 	networks := make([]*dockertest.Network, 0)
 	if f.docker.Network() != nil {
 		networks = append(networks, f.docker.Network())
 	}
+	sharedBuffers, workMem := memorySettings(f.memoryBudget(), f.parallelism)
 	opts := dockertest.RunOptions{
 		Name:       f.name + "_" + fixtures.GetRandomName(0),
 		Repository: f.repo,
@@ -82,16 +104,55 @@ func (f *fixture) SetUp(ctx context.Context) error {
 			"-c", "synchronous_commit=off",
 			"-c", "full_page_writes=off",
 			"-c", "random_page_cost=1.1",
-			"-c", fmt.Sprintf("shared_buffers=%vMB", fixtures.MemoryMB()/8),
-			"-c", fmt.Sprintf("work_mem=%vMB", fixtures.MemoryMB()/8),
+			"-c", fmt.Sprintf("shared_buffers=%vMB", sharedBuffers),
+			"-c", fmt.Sprintf("work_mem=%vMB", workMem),
 		},
 		Mounts: f.mounts,
 	}
+	if f.pgdata != "" {
+		opts.Env = append(opts.Env, "PGDATA="+f.pgdata)
+	}
+	for _, fn := range f.runOptions {
+		fn(&opts)
+	}
+	hostConfig := []func(*docker.HostConfig){}
+	if f.memoryMB > 0 {
+		hostConfig = append(hostConfig, func(hc *docker.HostConfig) {
+			hc.Memory = int64(f.memoryMB) << 20
+			hc.MemorySwap = hc.Memory
+		})
+	}
+	hostConfig = append(hostConfig, f.hostConfig...)
 
 	var err error
-	f.resource, err = f.docker.Pool().RunWithOptions(&opts)
-	if err != nil {
-		return err
+	if f.tmpfsMB > 0 && f.pgdata != "" {
+		f.log.Warn("ignoring tmpfs, the data directory must be committable", zap.String("pgdata", f.pgdata))
+	} else if f.tmpfsMB > 0 {
+		tmpfsOpts := opts
+		tmpfsOpts.Env = append(append([]string{}, opts.Env...), "PGDATA="+tmpfsPGDATA)
+		tmpfs := func(hc *docker.HostConfig) {
+			if hc.Tmpfs == nil {
+				hc.Tmpfs = map[string]string{}
+			}
+			hc.Tmpfs[tmpfsPGDATA] = fmt.Sprintf("rw,size=%vm", f.tmpfsMB)
+		}
+		f.resource, err = f.docker.Pool().RunWithOptions(&tmpfsOpts, append([]func(*docker.HostConfig){tmpfs}, hostConfig...)...)
+		if err != nil {
+			// Some runtimes (e.g. rootless or remote daemons) don't support tmpfs mounts.
+			f.log.Warn("failed to start with tmpfs, falling back to disk", zap.Error(err))
+			// Reuse the name once the failed container is gone, so HostName stays the one derived from OptName;
+			// only pick a fresh one if the failed container is still holding it.
+			if err := f.docker.Pool().RemoveContainerByName(tmpfsOpts.Name); err != nil {
+				f.log.Debug("failed to remove container", zap.String("container", tmpfsOpts.Name), zap.Error(err))
+				opts.Name = f.name + "_" + fixtures.GetRandomName(0)
+			}
+		}
+	}
+	if f.resource == nil {
+		f.resource, err = f.docker.Pool().RunWithOptions(&opts, hostConfig...)
+		if err != nil {
+			return err
+		}
 	}
 
 	f.settings.Host = fixtures.ContainerAddress(f.resource, f.docker.Network())
@@ -157,6 +218,97 @@ func ConnOptCreateCopy() ConnOpt {
 	}
 }
 
+// ConnOptMaxConns sets the maximum size of the pool. Defaults to ConnectionSettings.MaxOpenConns, if set, or pgxpool's
+// default.
+func ConnOptMaxConns(n int32) ConnOpt {
+	return func(f *connConfig) {
+		f.poolConfig.MaxConns = n
+	}
+}
+
+// ConnOptMinConns sets the minimum size of the pool.
+func ConnOptMinConns(n int32) ConnOpt {
+	return func(f *connConfig) {
+		f.poolConfig.MinConns = n
+	}
+}
+
+// ConnOptAfterConnect runs fn on every new connection, e.g. to register custom types. Multiple hooks run in order.
+func ConnOptAfterConnect(fn func(context.Context, *pgx.Conn) error) ConnOpt {
+	return func(f *connConfig) {
+		previous := f.poolConfig.AfterConnect
+		if previous == nil {
+			f.poolConfig.AfterConnect = fn
+			return
+		}
+		f.poolConfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+			if err := previous(ctx, conn); err != nil {
+				return err
+			}
+			return fn(ctx, conn)
+		}
+	}
+}
+
+// ConnOptBeforeAcquire runs fn before a connection is acquired from the pool. If it returns false, the connection is
+// destroyed and another is acquired. Multiple hooks must all return true.
+func ConnOptBeforeAcquire(fn func(context.Context, *pgx.Conn) bool) ConnOpt {
+	return func(f *connConfig) {
+		previous := f.poolConfig.BeforeAcquire
+		if previous == nil {
+			f.poolConfig.BeforeAcquire = fn
+			return
+		}
+		f.poolConfig.BeforeAcquire = func(ctx context.Context, conn *pgx.Conn) bool {
+			return previous(ctx, conn) && fn(ctx, conn)
+		}
+	}
+}
+
+// ConnOptStatementCacheMode sets how statements are cached: stmtcache.ModePrepare (the default) prepares named
+// statements, while stmtcache.ModeDescribe only caches their descriptions, as required by poolers like PgBouncer.
+func ConnOptStatementCacheMode(mode int) ConnOpt {
+	return func(f *connConfig) {
+		f.poolConfig.ConnConfig.BuildStatementCache = func(conn *pgconn.PgConn) stmtcache.Cache {
+			return stmtcache.New(conn, mode, 512)
+		}
+	}
+}
+
+// ConnOptApplicationName sets application_name, which identifies connections in pg_stat_activity and logs.
+func ConnOptApplicationName(name string) ConnOpt {
+	return ConnOptRuntimeParams(map[string]string{"application_name": name})
+}
+
+// ConnOptSearchPath sets the schemas searched for unqualified names, in order.
+func ConnOptSearchPath(schemas ...string) ConnOpt {
+	identifiers := make([]string, len(schemas))
+	for i, s := range schemas {
+		identifiers[i] = pgx.Identifier{s}.Sanitize()
+	}
+	return ConnOptRuntimeParams(map[string]string{"search_path": strings.Join(identifiers, ", ")})
+}
+
+// ConnOptRuntimeParams sets run-time parameters (e.g. `statement_timeout`) when connecting.
+func ConnOptRuntimeParams(params map[string]string) ConnOpt {
+	return func(f *connConfig) {
+		if f.poolConfig.ConnConfig.RuntimeParams == nil {
+			f.poolConfig.ConnConfig.RuntimeParams = map[string]string{}
+		}
+		for k, v := range params {
+			f.poolConfig.ConnConfig.RuntimeParams[k] = v
+		}
+	}
+}
+
+// ConnOptLogger logs queries and other pgx activity at or above level. See github.com/jackc/pgx/v4/log for adapters.
+func ConnOptLogger(logger pgx.Logger, level pgx.LogLevel) ConnOpt {
+	return func(f *connConfig) {
+		f.poolConfig.ConnConfig.Logger = logger
+		f.poolConfig.ConnConfig.LogLevel = level
+	}
+}
+
 func (f *fixture) Connect(ctx context.Context, opts ...ConnOpt) (*pgxpool.Pool, error) {
 	poolConfig, err := f.Settings().PoolConfig()
 	if err != nil {
@@ -178,17 +330,16 @@ func (f *fixture) Connect(ctx context.Context, opts ...ConnOpt) (*pgxpool.Pool,
 		}
 		cfg.poolConfig.ConnConfig.Database = copiedDatabaseName
 	}
-	pool, err := pgxpool.ConnectConfig(ctx, cfg.poolConfig)
-	if err != nil {
-		return nil, err
-	}
 	if cfg.role != "" {
-		_, err := pool.Exec(ctx, "set role "+cfg.role)
-		if err != nil {
-			return nil, fmt.Errorf("failed to assume role '%v': %w", cfg.role, err)
-		}
+		// Every pooled connection must assume the role, not just the first one to run a query.
+		ConnOptAfterConnect(func(ctx context.Context, conn *pgx.Conn) error {
+			if _, err := conn.Exec(ctx, "set role "+cfg.role); err != nil {
+				return fmt.Errorf("failed to assume role '%v': %w", cfg.role, err)
+			}
+			return nil
+		})(cfg)
 	}
-	return pool, nil
+	return pgxpool.ConnectConfig(ctx, cfg.poolConfig)
 }
 
 func (f *fixture) MustConnect(ctx context.Context, opts ...ConnOpt) *pgxpool.Pool {
@@ -296,32 +447,14 @@ func (f *fixture) DropDatabase(ctx context.Context, name string) error {
 	if err != nil {
 		return err
 	}
+	// Our own connection would otherwise block the drop.
+	db.Close()
 
 	exitCode, err := f.Psql(ctx, []string{"dropdb", name}, []string{}, false)
 	f.log.Debug("drop database", zap.Int("status", exitCode), zap.String("database", name), zap.String("container", f.HostName()))
 	return err
 }
 
-func (f *fixture) Dump(ctx context.Context, dir string, filename string) error {
-	path := fixtures.FindPath(dir)
-	if path == "" {
-		return fmt.Errorf("could not resolve path: %v", dir)
-	}
-	exitCode, err := f.Psql(ctx, []string{"sh", "-c", fmt.Sprintf("pg_dump -Fc -Z0 %v > /tmp/%v", f.settings.Database, filename)}, []string{fmt.Sprintf("%v:/tmp", path)}, false)
-	f.log.Debug("dump database", zap.Int("status", exitCode), zap.String("database", f.settings.Database), zap.String("container", f.HostName()), zap.String("path", path))
-	return err
-}
-
-func (f *fixture) Restore(ctx context.Context, dir string, filename string) error {
-	path := fixtures.FindPath(dir)
-	if path == "" {
-		return fmt.Errorf("could not resolve path: %v", dir)
-	}
-	exitCode, err := f.Psql(ctx, []string{"sh", "-c", fmt.Sprintf("pg_restore --dbname=%v --verbose --single-transaction /tmp/%v", f.settings.Database, filename)}, []string{fmt.Sprintf("%v:/tmp", path)}, false)
-	f.log.Debug("restore database", zap.Int("status", exitCode), zap.String("database", f.settings.Database), zap.String("container", f.HostName()), zap.String("path", path))
-	return err
-}
-
 // LoadSql runs a file or directory of *.sql files against the default postgres database.
 func (f *fixture) LoadSql(ctx context.Context, path string) error {
 	load := func(p string) error {
@@ -428,44 +561,42 @@ func (f *fixture) TableExists(ctx context.Context, database, schema, table strin
 	return count == 1, nil
 }
 
-func (f *fixture) TableColumns(ctx context.Context, database, schema, table string) ([]string, error) {
-	db, err := f.Connect(ctx, ConnOptDatabase(database))
+func (f *fixture) DatabaseExists(ctx context.Context, name string) (bool, error) {
+	db, err := f.Connect(ctx)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 	defer db.Close()
-	var columnNames pgtype.TextArray
-	query := fmt.Sprintf("SELECT array_agg(column_name::text) FROM information_schema.columns WHERE table_schema = '%v' AND table_name = '%v'", schema, table)
-	if err := db.QueryRow(ctx,
-		query,
-	).Scan(&columnNames); err != nil {
+	var exists bool
+	if err := db.QueryRow(ctx, "SELECT EXISTS (SELECT FROM pg_catalog.pg_database WHERE datname = $1)", name).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to query: %w", err)
+	}
+	return exists, nil
+}
+
+// TableColumns returns the column names of a table, in ordinal order. Use Columns for type information.
+func (f *fixture) TableColumns(ctx context.Context, database, schema, table string) ([]string, error) {
+	columns, err := f.Columns(ctx, database, schema, table)
+	if err != nil {
 		return nil, err
 	}
-	cols := make([]string, len(columnNames.Elements))
-	for _, text := range columnNames.Elements {
-		cols = append(cols, text.String)
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
 	}
-	return cols, nil
+	return names, nil
 }
 
+// Tables returns the names of tables (including partitioned tables) in all user schemas.
+// Use Relations for schema-qualified results, views and other relation kinds.
 func (f *fixture) Tables(ctx context.Context, database string) ([]string, error) {
-	db, err := f.Connect(ctx, ConnOptDatabase(database))
+	relations, err := f.Relations(ctx, database, IntrospectOptKind(RelationTable, RelationPartitionedTable))
 	if err != nil {
 		return nil, err
 	}
-	defer db.Close()
-	tables := []string{}
-	rows, err := db.Query(ctx, "SELECT tablename FROM pg_catalog.pg_tables WHERE schemaname != 'information_schema' AND schemaname != 'pg_catalog'")
-	if err != nil {
-		return nil, fmt.Errorf("failed to query: %w", err)
-	}
-	for rows.Next() {
-		var table string
-		err := rows.Scan(&table)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan: %w", err)
-		}
-		tables = append(tables, table)
+	tables := make([]string, len(relations))
+	for i, r := range relations {
+		tables[i] = r.Name
 	}
 	return tables, nil
 }
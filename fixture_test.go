@@ -1,19 +1,32 @@
 package pgtest
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/charlieparkes/go-fixtures/v2"
+	"github.com/jackc/pgconn/stmtcache"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestPostgres(t *testing.T) {
 	ctx := context.Background()
-	opts := []Opt{OptNetworkName(os.Getenv("HOST_NETWORK_NAME"))}
+	opts := []Opt{
+		OptNetworkName(os.Getenv("HOST_NETWORK_NAME")),
+		OptTmpfs(256),
+		OptShmSize(128),
+		OptLabels(map[string]string{"pgtest.test": t.Name()}),
+		OptEnv("TZ=UTC"),
+	}
 
 	p, err := NewPostgres(ctx, opts...)
 	require.NoError(t, err)
@@ -21,6 +34,11 @@ func TestPostgres(t *testing.T) {
 
 	require.NoError(t, p.PingPsql(ctx))
 
+	// Container options
+	assert.Equal(t, t.Name(), p.resource.Container.Config.Labels["pgtest.test"])
+	assert.Contains(t, p.resource.Container.Config.Env, "TZ=UTC")
+	assert.Equal(t, int64(128)<<20, p.resource.Container.HostConfig.ShmSize)
+
 	// Connect
 	db, err := p.Connect(ctx)
 	require.NoError(t, err)
@@ -28,6 +46,51 @@ func TestPostgres(t *testing.T) {
 		db.Close()
 	}
 
+	// Connect (options)
+	var connected int32
+	db, err = p.Connect(ctx,
+		ConnOptMaxConns(2),
+		ConnOptApplicationName("pgtest"),
+		ConnOptSearchPath("pg_catalog", "public"),
+		ConnOptRuntimeParams(map[string]string{"statement_timeout": "5s"}),
+		ConnOptStatementCacheMode(stmtcache.ModeDescribe),
+		ConnOptAfterConnect(func(ctx context.Context, conn *pgx.Conn) error {
+			atomic.AddInt32(&connected, 1)
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), db.Config().MaxConns)
+	var applicationName, searchPath, statementTimeout string
+	require.NoError(t, db.QueryRow(ctx, "SELECT current_setting('application_name'), current_setting('search_path'), current_setting('statement_timeout')").Scan(&applicationName, &searchPath, &statementTimeout))
+	assert.Equal(t, "pgtest", applicationName)
+	assert.Contains(t, searchPath, "public")
+	assert.Equal(t, "5s", statementTimeout)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&connected))
+	db.Close()
+
+	// Connect (role applies to every pooled connection)
+	db, err = p.Connect(ctx)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, "CREATE ROLE pgtest_role")
+	require.NoError(t, err)
+	db.Close()
+	db, err = p.Connect(ctx, ConnOptRole("pgtest_role"), ConnOptMinConns(2), ConnOptMaxConns(2))
+	require.NoError(t, err)
+	conns := []*pgxpool.Conn{}
+	for i := 0; i < 2; i++ {
+		conn, err := db.Acquire(ctx)
+		require.NoError(t, err)
+		conns = append(conns, conn)
+		var currentUser string
+		require.NoError(t, conn.QueryRow(ctx, "SELECT current_user").Scan(&currentUser))
+		assert.Equal(t, "pgtest_role", currentUser)
+	}
+	for _, conn := range conns {
+		conn.Release()
+	}
+	db.Close()
+
 	// LoadSqlPattern, Tables, TableExists
 	exists, err := p.TableExists(ctx, "", "public", "address")
 	assert.NoError(t, err)
@@ -48,8 +111,200 @@ func TestPostgres(t *testing.T) {
 		fmt.Println(tables)
 	}
 
+	// Relations, Sequences, Indexes, Constraints
+	relations, err := p.Relations(ctx, "", IntrospectOptSchema("public"), IntrospectOptKind(RelationTable))
+	require.NoError(t, err)
+	assert.Equal(t, []Table{
+		{Schema: "public", Name: "address", Kind: RelationTable},
+		{Schema: "public", Name: "person", Kind: RelationTable},
+	}, relations)
+
+	sequences, err := p.Sequences(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, sequences, 2)
+	for _, seq := range sequences {
+		assert.NotEmpty(t, seq.OwnedBy)
+	}
+
+	indexes, err := p.Indexes(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, indexes, 1)
+
+	constraints, err := p.Constraints(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, constraints, 2)
+
+	// AssertSchemaGolden
+	AssertSchemaGolden(t, p, "", "testdata/schema.golden")
+
+	// Columns
+	columns, err := p.Columns(ctx, "", "public", "person")
+	require.NoError(t, err)
+	require.Len(t, columns, 4)
+	assert.Equal(t, "id", columns[0].Name)
+	assert.Equal(t, "int4", columns[0].UDTName)
+	assert.False(t, columns[0].Nullable)
+	assert.True(t, columns[0].HasDefault())
+	assert.Equal(t, "first_name", columns[1].Name)
+	assert.Equal(t, "text", columns[1].DataType)
+	assert.True(t, columns[1].Nullable)
+
 	// ValidateModel
 	require.NoError(t, ValidateModels(ctx, p, "", &Person{}))
+	err = ValidateModelsWithOpts(ctx, p, "", []interface{}{&Person{}, &PersonView{}}, ValidateOptStrictness(ValidateStrictnessNames))
+	require.ErrorContains(t, err, "person_view")
+	err = ValidateModel(ctx, p, "", &Person{}, ValidateOptStrictness(ValidateStrictnessExact))
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Len(t, verr.Fields, 4)
+	err = ValidateModel(ctx, p, "", &Person{}, ValidateOptWriteOverflow())
+	require.ErrorAs(t, err, &verr)
+	assert.Len(t, verr.Fields, 2) // id and address_id are integer columns
+	err = ValidateModel(ctx, p, "", &BadPerson{})
+	require.ErrorAs(t, err, &verr)
+	assert.Len(t, verr.Fields, 3)
+	require.NoError(t, ValidateModel(ctx, p, "", &PartialPerson{}, ValidateOptRequireNotNullColumns()))
+	err = ValidateModel(ctx, p, "", &PartialPerson{}, ValidateOptRequireAllColumns())
+	require.ErrorAs(t, err, &verr)
+	assert.Len(t, verr.Fields, 3)
+	err = ValidateModel(ctx, p, "", &PartialPerson{}, ValidateOptRequireAllColumns(), ValidateOptIgnoreDefaults())
+	require.ErrorAs(t, err, &verr)
+	assert.Len(t, verr.Fields, 2)
+
+	// ValidateModel (views, functions and queries)
+	db, err = p.Connect(ctx)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, "CREATE VIEW person_view AS SELECT id, first_name, last_name, address_id FROM person")
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, "CREATE FUNCTION people(p_last_name text) RETURNS SETOF person AS 'SELECT * FROM person WHERE last_name = p_last_name' LANGUAGE sql")
+	require.NoError(t, err)
+	db.Close()
+	require.NoError(t, ValidateModel(ctx, p, "", &PersonView{}))
+	require.NoError(t, ValidateModel(ctx, p, "", &Person{}, ValidateOptFunction("public.people")))
+	require.NoError(t, ValidateModel(ctx, p, "", &Person{}, ValidateOptQuery("SELECT p.*, a.city FROM person p JOIN address a ON a.id = p.address_id")))
+	err = ValidateModel(ctx, p, "", &Person{}, ValidateOptQuery("SELECT id, first_name FROM person"))
+	require.ErrorAs(t, err, &verr)
+	assert.Len(t, verr.Fields, 2)
+
+	// ValidateQuery
+	people := []Person{}
+	require.NoError(t, ValidateQuery(ctx, p, "", "SELECT * FROM person WHERE last_name = $1", &people))
+	err = ValidateQuery(ctx, p, "", "SELECT p.*, a.city, a.id FROM person p JOIN address a ON a.id = p.address_id", &Person{})
+	require.ErrorAs(t, err, &verr)
+	assert.Len(t, verr.Fields, 2)
+	err = ValidateQuery(ctx, p, "", "SELECT * FROM persons", &Person{})
+	assert.Error(t, err)
+
+	// CheckStatements
+	require.NoError(t, CheckStatements(ctx, p, "", "SELECT * FROM person WHERE id = $1", "UPDATE address SET city = $1 WHERE id = $2"))
+	err = CheckStatements(ctx, p, "", "SELECT * FROM persons", "SELECT nope FROM person", "SELEC 1", "SELECT 1")
+	var serr StatementErrors
+	require.ErrorAs(t, err, &serr)
+	assert.Len(t, serr, 3)
+	require.NoError(t, CheckStatementFiles(ctx, p, "", "testdata/migrations"))
+
+	// ValidateRegisteredModels
+	RegisterModel(&Person{})
+	RegisterModel(&PersonView{})
+	RegisterModel(&Person{}, ValidateOptFunction("public.people"))
+	ValidateRegisteredModels(t, p, "")
+
+	// LoadFixtures
+	var count int
+	require.NoError(t, p.LoadFixtures(ctx, "", "testdata/fixtures"))
+	db, err = p.Connect(ctx)
+	require.NoError(t, err)
+	var maxID int
+	require.NoError(t, db.QueryRow(ctx, "SELECT count(*), max(id) FROM person").Scan(&count, &maxID))
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 10, maxID)
+	var nextID int
+	require.NoError(t, db.QueryRow(ctx, "INSERT INTO person (first_name) VALUES ('Grace') RETURNING id").Scan(&nextID))
+	assert.Equal(t, 11, nextID)
+	db.Close()
+
+	// CopyOut
+	addresses := bytes.Buffer{}
+	n, err := p.CopyOut(ctx, "", "address", &addresses, CSVOptions{Header: true})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	n, err = p.CopyOutFile(ctx, "", "SELECT first_name, last_name FROM person ORDER BY id", "testdata/tmp/people.csv", CSVOptions{Null: "NULL"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	// Truncate
+	db, err = p.Connect(ctx)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, "INSERT INTO address (city) VALUES ('Springfield')")
+	require.NoError(t, err)
+	// person references address, so it can't be kept while address is emptied.
+	assert.ErrorContains(t, p.Truncate(ctx, "", TruncateOptExclude("person")), "foreign key")
+	require.NoError(t, db.QueryRow(ctx, "SELECT count(*) FROM person").Scan(&count))
+	assert.Equal(t, 3, count)
+	require.NoError(t, p.Truncate(ctx, "", TruncateOptExclude("address")))
+	require.NoError(t, db.QueryRow(ctx, "SELECT count(*) FROM person").Scan(&count))
+	assert.Equal(t, 0, count)
+	require.NoError(t, db.QueryRow(ctx, "SELECT count(*) FROM address").Scan(&count))
+	assert.Equal(t, 3, count)
+	require.NoError(t, p.Truncate(ctx, ""))
+	require.NoError(t, db.QueryRow(ctx, "SELECT count(*) FROM address").Scan(&count))
+	assert.Equal(t, 0, count)
+	db.Close()
+
+	// CopyIn
+	n, err = p.CopyIn(ctx, "", "public.address", &addresses, CSVOptions{Header: true})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	n, err = p.CopyInFile(ctx, "", "person", "testdata/tmp/people.csv", CSVOptions{Null: "NULL", Columns: []string{"first_name", "last_name"}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	// Insert
+	db, err = p.Connect(ctx)
+	require.NoError(t, err)
+	var addressId int64
+	require.NoError(t, db.QueryRow(ctx, "SELECT min(id) FROM address").Scan(&addressId))
+	inserted, err := Insert(ctx, db, Person{FirstName: "Ned", LastName: "Flanders", AddressId: addressId})
+	require.NoError(t, err)
+	require.Len(t, inserted, 1)
+	assert.NotZero(t, inserted[0].Id)
+	assert.Equal(t, "Ned", inserted[0].FirstName)
+
+	// Factory
+	personFactory := NewFactory(db, func(n int) Person {
+		return Person{FirstName: fmt.Sprintf("Person%v", n), LastName: "Factory", AddressId: addressId}
+	})
+	created, err := personFactory.CreateN(ctx, 2, func(p *Person) { p.LastName = "Override" })
+	require.NoError(t, err)
+	require.Len(t, created, 2)
+	assert.NotEqual(t, created[0].FirstName, created[1].FirstName)
+	assert.NotEqual(t, created[0].Id, created[1].Id)
+	assert.Equal(t, "Override", created[1].LastName)
+
+	// InsertWithOpts, Factory (zero-valued overrides of defaulted columns)
+	_, err = db.Exec(ctx, "CREATE TABLE member (id serial PRIMARY KEY, name text NOT NULL, active bool NOT NULL DEFAULT true, created_at timestamptz NOT NULL DEFAULT now())")
+	require.NoError(t, err)
+	memberFactory := NewFactory(db, func(n int) Member {
+		return Member{Name: fmt.Sprintf("member%v", n), Active: true}
+	}).OmitZero("created_at")
+	member, err := memberFactory.Create(ctx, func(m *Member) { m.Active = false })
+	require.NoError(t, err)
+	assert.NotZero(t, member.Id)
+	assert.False(t, member.Active)
+	assert.False(t, member.CreatedAt.IsZero())
+	var active bool
+	require.NoError(t, db.QueryRow(ctx, "SELECT active FROM member WHERE id = $1", member.Id).Scan(&active))
+	assert.False(t, active)
+	members, err := InsertWithOpts(ctx, db, []Member{{Name: "insert"}}, InsertOptOmitZero("created_at"))
+	require.NoError(t, err)
+	assert.False(t, members[0].Active)
+	assert.False(t, members[0].CreatedAt.IsZero())
+	_, err = db.Exec(ctx, "DROP TABLE member")
+	require.NoError(t, err)
+	db.Close()
+
+	// VerifyMigrations
+	require.NoError(t, p.VerifyMigrations(ctx, "testdata/updown"))
 
 	// Dump
 	require.NoError(t, p.Dump(ctx, "testdata/tmp", "test.pgdump"))
@@ -78,6 +333,70 @@ func TestPostgres(t *testing.T) {
 	require.NoError(t, err)
 	assert.Len(t, tables, 2)
 
+	// Dump (options)
+	require.NoError(t, p.Dump(ctx, "testdata/tmp", "schema.sql", DumpOptDatabase(databaseName), DumpOptFormat(DumpFormatPlain), DumpOptSchemaOnly()))
+	schemaDump, err := os.ReadFile("testdata/tmp/schema.sql")
+	require.NoError(t, err)
+	assert.Contains(t, string(schemaDump), "CREATE TABLE public.person")
+	assert.NotContains(t, string(schemaDump), "COPY public.person")
+	require.NoError(t, p.Dump(ctx, "testdata/tmp", "address.tar", DumpOptFormat(DumpFormatTar), DumpOptTables("public.address")))
+
+	// Restore (options)
+	restoredName := fixtures.GetRandomName(0)
+	require.NoError(t, p.Restore(ctx, "testdata/tmp", "schema.sql", RestoreOptDatabase(restoredName)))
+	tables, err = p.Tables(ctx, restoredName)
+	require.NoError(t, err)
+	assert.Len(t, tables, 2)
+	assert.Error(t, p.Restore(ctx, "testdata/tmp", "test.pgdump", RestoreOptDatabase(databaseName)))
+	require.NoError(t, p.Restore(ctx, "testdata/tmp", "test.pgdump", RestoreOptDatabase(databaseName), RestoreOptClean(), RestoreOptNoOwner()))
+	require.NoError(t, p.Restore(ctx, "testdata/tmp", "address.tar", RestoreOptDatabase(fixtures.GetRandomName(0))))
+
+	// DumpTo, RestoreFrom
+	archive := bytes.Buffer{}
+	require.NoError(t, p.DumpTo(ctx, &archive))
+	assert.True(t, bytes.HasPrefix(archive.Bytes(), []byte("PGDMP")))
+	streamedName := fixtures.GetRandomName(0)
+	require.NoError(t, p.RestoreFrom(ctx, &archive, RestoreOptDatabase(streamedName)))
+	tables, err = p.Tables(ctx, streamedName)
+	require.NoError(t, err)
+	assert.Len(t, tables, 2)
+	script := bytes.Buffer{}
+	require.NoError(t, p.DumpTo(ctx, &script, DumpOptFormat(DumpFormatPlain), DumpOptSchemaOnly()))
+	require.NoError(t, p.RestoreFrom(ctx, &script, RestoreOptDatabase(fixtures.GetRandomName(0))))
+	assert.Error(t, p.DumpTo(ctx, &script, DumpOptFormat(DumpFormatDirectory)))
+
+	// DiffSchemas
+	diff, err := p.DiffSchemas(ctx, "", databaseName)
+	require.NoError(t, err)
+	assert.True(t, diff.Empty(), diff.String())
+	diff, err = p.DiffSchemas(ctx, "", name)
+	require.NoError(t, err)
+	assert.False(t, diff.Empty())
+
+	// LoadFixtures (domains, enums, composites and empty rows)
+	db, err = p.Connect(ctx, ConnOptDatabase(name))
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `
+		CREATE DOMAIN positive AS int CHECK (VALUE > 0);
+		CREATE DOMAIN thing_id AS uuid;
+		CREATE TYPE status AS ENUM ('active', 'retired');
+		CREATE TYPE dimensions AS (width int, height int);
+		CREATE TABLE thing (id thing_id PRIMARY KEY, quantity positive, status status, statuses status[], dimensions dimensions);
+		CREATE TABLE tally (id serial PRIMARY KEY, "label,text" text NOT NULL DEFAULT 'none');
+	`)
+	require.NoError(t, err)
+	require.NoError(t, p.LoadFixtures(ctx, name, "testdata/typed"))
+	var quantity, height int
+	var statuses []string
+	require.NoError(t, db.QueryRow(ctx, "SELECT quantity, statuses::text[], (dimensions).height FROM thing").Scan(&quantity, &statuses, &height))
+	assert.Equal(t, 3, quantity)
+	assert.Equal(t, []string{"active", "retired"}, statuses)
+	assert.Equal(t, 3, height)
+	var labels []string
+	require.NoError(t, db.QueryRow(ctx, `SELECT array_agg("label,text" ORDER BY "label,text") FROM tally`).Scan(&labels))
+	assert.Equal(t, []string{"counted", "none"}, labels)
+	db.Close()
+
 	// Original exists.
 	exists, err = p.TableExists(ctx, "", "public", "address")
 	assert.NoError(t, err)
@@ -134,6 +453,67 @@ func TestPostgres(t *testing.T) {
 	require.NoError(t, p2.TearDown(ctx))
 }
 
+func TestPostgresMigrations(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+	opts := []Opt{
+		OptNetworkName(os.Getenv("HOST_NETWORK_NAME")),
+		OptMigrations("testdata/updown"),
+		OptCacheDir(cacheDir),
+		OptMemoryBudget(512, 4),
+	}
+
+	// Cache miss
+	p, err := NewPostgres(ctx, opts...)
+	require.NoError(t, err)
+	defer p.RecoverTearDown(ctx)
+	require.NotEmpty(t, p.MigrationsHash())
+	assert.False(t, p.restoredFromCache)
+	dump := filepath.Join(cacheDir, p.MigrationsHash()+".pgdump")
+	require.FileExists(t, dump)
+	before, err := os.Stat(dump)
+	require.NoError(t, err)
+	tables, err := p.Tables(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, tables, 2)
+	require.NoError(t, p.TearDown(ctx))
+
+	// Cache hit
+	p2, err := NewPostgres(ctx, opts...)
+	require.NoError(t, err)
+	defer p2.RecoverTearDown(ctx)
+	assert.Equal(t, p.MigrationsHash(), p2.MigrationsHash())
+	assert.True(t, p2.restoredFromCache)
+	after, err := os.Stat(dump)
+	require.NoError(t, err)
+	assert.Equal(t, before.ModTime(), after.ModTime(), "a cache hit should not rewrite the dump")
+	columns, err := p2.TableColumns(ctx, "", "public", "person")
+	require.NoError(t, err)
+	assert.Contains(t, columns, "email")
+	require.NoError(t, p2.TearDown(ctx))
+
+	// Image cache miss
+	opts = append(opts, OptImageCache())
+	p3, err := NewPostgres(ctx, opts...)
+	require.NoError(t, err)
+	defer p3.RecoverTearDown(ctx)
+	image := imageRepository + ":" + p3.imageCacheTag()
+	defer p3.docker.Pool().Client.RemoveImage(image)
+	require.NoError(t, p3.TearDown(ctx))
+
+	// Image cache hit
+	p4, err := NewPostgres(ctx, opts...)
+	require.NoError(t, err)
+	defer p4.RecoverTearDown(ctx)
+	assert.True(t, p4.cachedImage)
+	assert.Equal(t, image, p4.repo+":"+p4.version)
+	assert.Equal(t, p3.settings.Password, p4.settings.Password)
+	columns, err = p4.TableColumns(ctx, "", "public", "person")
+	require.NoError(t, err)
+	assert.Contains(t, columns, "email")
+	require.NoError(t, p4.TearDown(ctx))
+}
+
 type Person struct {
 	Id        int64
 	FirstName string
@@ -141,3 +521,36 @@ type Person struct {
 	AddressId int64
 	FooBar    bool `db:"-"`
 }
+
+type BadPerson struct {
+	Id        string
+	FirstName int
+	Missing   bool
+}
+
+func (BadPerson) TableName() string {
+	return "person"
+}
+
+type PartialPerson struct {
+	FirstName string
+}
+
+func (PartialPerson) TableName() string {
+	return "person"
+}
+
+type PersonView struct {
+	Person
+}
+
+func (PersonView) TableName() string {
+	return "person_view"
+}
+
+type Member struct {
+	Id        int64
+	Name      string
+	Active    bool
+	CreatedAt time.Time
+}
@@ -0,0 +1,61 @@
+package pgtest
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// updateGolden reports whether golden files should be rewritten: when PGTEST_UPDATE_GOLDEN is true, or when the test
+// binary defines an -update flag (as many golden file libraries do) and it's set. pgtest doesn't define the flag itself.
+func updateGolden() bool {
+	if update, err := strconv.ParseBool(os.Getenv("PGTEST_UPDATE_GOLDEN")); err == nil {
+		return update
+	}
+	f := flag.Lookup("update")
+	if f == nil {
+		return false
+	}
+	update, _ := strconv.ParseBool(f.Value.String())
+	return update
+}
+
+// AssertSchemaGolden renders the schema of a database (see Schema.String) and compares it with a golden file, so
+// schema changes are visible in code review. Run tests with PGTEST_UPDATE_GOLDEN=true to rewrite the golden file.
+func AssertSchemaGolden(t testing.TB, f *Postgres, databaseName string, path string, opts ...IntrospectOpt) bool {
+	t.Helper()
+	schema, err := f.Schema(context.Background(), databaseName, opts...)
+	if err != nil {
+		t.Errorf("failed to snapshot schema: %v", err)
+		return false
+	}
+	actual := schema.String()
+
+	if updateGolden() {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Errorf("failed to create golden file directory: %v", err)
+			return false
+		}
+		if err := os.WriteFile(path, []byte(actual), 0o644); err != nil {
+			t.Errorf("failed to write golden file: %v", err)
+			return false
+		}
+		return true
+	}
+
+	expected, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Errorf("golden file %v does not exist (run with PGTEST_UPDATE_GOLDEN=true to create it)", path)
+		return false
+	} else if err != nil {
+		t.Errorf("failed to read golden file: %v", err)
+		return false
+	}
+	return assert.Equal(t, string(expected), actual, "schema does not match golden file %v (run with PGTEST_UPDATE_GOLDEN=true to rewrite it)", path)
+}
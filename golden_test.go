@@ -0,0 +1,16 @@
+package pgtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateGolden(t *testing.T) {
+	t.Setenv("PGTEST_UPDATE_GOLDEN", "true")
+	assert.True(t, updateGolden())
+	t.Setenv("PGTEST_UPDATE_GOLDEN", "false")
+	assert.False(t, updateGolden())
+	t.Setenv("PGTEST_UPDATE_GOLDEN", "")
+	assert.False(t, updateGolden())
+}
@@ -0,0 +1,88 @@
+package pgtest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/ory/dockertest/v3/docker"
+	"go.uber.org/zap"
+)
+
+// The official postgres image declares its default data directory a volume, which `docker commit` doesn't capture.
+const committablePGDATA = "/var/lib/postgresql/pgtest"
+
+const (
+	// Images are committed to their own repository, so they aren't mixed up with the upstream image's tags.
+	imageRepository = "pgtest"
+	// initdb doesn't run again in a container started from a committed image, so the default password must be the
+	// same every time for it to still work. These containers are only for tests.
+	committedPassword = "pgtest"
+)
+
+// OptImageCache caches the database migrated by OptMigrations as a local docker image, tagged by the migrations hash
+// and connection settings (`pgtest:{version}-{hash}`). If the image exists, the container starts from it and
+// migrations are skipped entirely; otherwise, the container is committed after migrating.
+//
+// This also keeps the data directory out of a volume, so CommitImage can be used without OptMigrations.
+func OptImageCache() Opt {
+	return func(f *Postgres) {
+		f.imageCache = true
+		f.pgdata = committablePGDATA
+	}
+}
+
+// imageCacheTag identifies an image with the current migrations applied, which accepts the current credentials.
+func (p *Postgres) imageCacheTag() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v\x00%v\x00%v\x00%v", p.migrationsHash, p.settings.User, p.settings.Password, p.settings.Database)
+	return fmt.Sprintf("%v-%v", p.version, hex.EncodeToString(h.Sum(nil))[:16])
+}
+
+// useCachedImage switches to the cached image for the current migrations if it exists.
+func (p *Postgres) useCachedImage() error {
+	tag := p.imageCacheTag()
+	_, err := p.docker.Pool().Client.InspectImage(imageRepository + ":" + tag)
+	if errors.Is(err, docker.ErrNoSuchImage) {
+		p.log.Debug("image cache miss", zap.String("image", imageRepository+":"+tag))
+		return nil
+	} else if err != nil {
+		return err
+	}
+	p.repo = imageRepository
+	p.version = tag
+	p.cachedImage = true
+	p.log.Debug("image cache hit", zap.String("image", imageRepository+":"+tag))
+	return nil
+}
+
+// CommitImage checkpoints the database and commits the container as `pgtest:{tag}`, so later runs can start from it
+// with OptRepo("pgtest") and OptVersion(tag). They must use the same connection settings, since initdb won't run
+// again. The data directory must not be a volume; see OptImageCache.
+func (f *fixture) CommitImage(ctx context.Context, tag string) error {
+	if f.pgdata != committablePGDATA {
+		return errors.New("the data directory is a volume and would not be committed; use OptImageCache")
+	}
+	db, err := f.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, "CHECKPOINT")
+	db.Close()
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint: %w", err)
+	}
+	image, err := f.docker.Pool().Client.CommitContainer(docker.CommitContainerOptions{
+		Container:  f.resource.Container.ID,
+		Repository: imageRepository,
+		Tag:        tag,
+		Context:    ctx,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit container: %w", err)
+	}
+	f.log.Debug("commit image", zap.String("image", imageRepository+":"+tag), zap.String("id", image.ID), zap.String("container", f.HostName()))
+	return nil
+}
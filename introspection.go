@@ -0,0 +1,574 @@
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+type RelationKind string
+
+const (
+	RelationTable            RelationKind = "table"
+	RelationPartitionedTable RelationKind = "partitioned table"
+	RelationView             RelationKind = "view"
+	RelationMaterializedView RelationKind = "materialized view"
+	RelationForeignTable     RelationKind = "foreign table"
+)
+
+// Table describes a relation which holds or presents rows (tables, views, materialized views, etc.)
+type Table struct {
+	Schema      string
+	Name        string
+	Kind        RelationKind
+	IsPartition bool
+	// RowEstimate is the planner's estimate (pg_class.reltuples) and is 0 until the relation has been analyzed.
+	RowEstimate int64
+}
+
+func (t Table) String() string {
+	return t.Schema + "." + t.Name
+}
+
+type Column struct {
+	Name string
+	// DataType is the SQL type name without modifiers, e.g. `character varying` or `timestamp with time zone`.
+	DataType string
+	// UDTName is the underlying type name, e.g. `varchar` or `timestamptz`. Arrays are prefixed with an underscore.
+	UDTName  string
+	TypeOID  uint32
+	Nullable bool
+	// Default is the default expression, or empty if the column has none.
+	Default     string
+	Position    int
+	IsIdentity  bool
+	IsGenerated bool
+	// CharacterMaximumLength is the declared length of char/varchar columns, or 0 if unbounded.
+	CharacterMaximumLength int
+}
+
+// HasDefault reports whether the database will supply a value for the column when it's omitted from an insert.
+func (c Column) HasDefault() bool {
+	return c.Default != "" || c.IsIdentity || c.IsGenerated
+}
+
+type View struct {
+	Schema       string
+	Name         string
+	Materialized bool
+	Definition   string
+}
+
+type Sequence struct {
+	Schema    string
+	Name      string
+	DataType  string
+	Start     int64
+	Increment int64
+	// OwnedBy is the `schema.table.column` which owns the sequence, if any (e.g. serial and identity columns).
+	OwnedBy string
+}
+
+type Function struct {
+	Schema    string
+	Name      string
+	Arguments string
+	Result    string
+	// Kind is one of function, procedure, aggregate or window.
+	Kind       string
+	Language   string
+	Definition string
+}
+
+type Index struct {
+	Schema     string
+	Table      string
+	Name       string
+	Unique     bool
+	Primary    bool
+	Definition string
+}
+
+type Constraint struct {
+	Schema string
+	Table  string
+	Name   string
+	// Type is one of PRIMARY KEY, FOREIGN KEY, UNIQUE, CHECK, EXCLUDE or TRIGGER.
+	Type       string
+	Definition string
+	// References is the `schema.table` referenced by a foreign key, or empty for other types.
+	References string
+}
+
+type Trigger struct {
+	Schema     string
+	Table      string
+	Name       string
+	Enabled    bool
+	Definition string
+}
+
+type introspectConfig struct {
+	schemas []string
+	kinds   []RelationKind
+}
+
+type IntrospectOpt func(*introspectConfig)
+
+// IntrospectOptSchema limits results to the given schemas. Defaults to all schemas except pg_catalog,
+// information_schema and pg_toast.
+func IntrospectOptSchema(schemas ...string) IntrospectOpt {
+	return func(c *introspectConfig) {
+		c.schemas = append(c.schemas, schemas...)
+	}
+}
+
+// IntrospectOptKind limits Relations to the given kinds. Defaults to all kinds.
+func IntrospectOptKind(kinds ...RelationKind) IntrospectOpt {
+	return func(c *introspectConfig) {
+		c.kinds = append(c.kinds, kinds...)
+	}
+}
+
+func newIntrospectConfig(opts []IntrospectOpt) *introspectConfig {
+	cfg := &introspectConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// schemaFilter returns the schema names for binding to a `$1::text[] IS NULL OR nspname = ANY($1)` clause.
+func (c *introspectConfig) schemaFilter() []string {
+	if len(c.schemas) == 0 {
+		return nil
+	}
+	return c.schemas
+}
+
+func (c *introspectConfig) kindFilter() []string {
+	if len(c.kinds) == 0 {
+		return nil
+	}
+	kinds := make([]string, len(c.kinds))
+	for i, k := range c.kinds {
+		kinds[i] = string(k)
+	}
+	return kinds
+}
+
+const userSchemas = "n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg_toast%' AND n.nspname NOT LIKE 'pg_temp%'"
+
+// Queryer is satisfied by *pgxpool.Pool, *pgx.Conn and pgx.Tx.
+type Queryer interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// introspect connects to a database, runs query and calls scan for every row.
+func (f *fixture) introspect(ctx context.Context, database, query string, scan func(pgx.Rows) error, args ...interface{}) error {
+	db, err := f.Connect(ctx, ConnOptDatabase(database))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return queryAll(ctx, db, query, scan, args...)
+}
+
+// queryAll runs query and calls scan for every row.
+func queryAll(ctx context.Context, q Queryer, query string, scan func(pgx.Rows) error, args ...interface{}) error {
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return fmt.Errorf("failed to scan: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query: %w", err)
+	}
+	return nil
+}
+
+// Relations lists tables, partitioned tables, views, materialized views and foreign tables.
+func (f *fixture) Relations(ctx context.Context, database string, opts ...IntrospectOpt) ([]Table, error) {
+	db, err := f.Connect(ctx, ConnOptDatabase(database))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return relations(ctx, db, opts...)
+}
+
+func relations(ctx context.Context, q Queryer, opts ...IntrospectOpt) ([]Table, error) {
+	cfg := newIntrospectConfig(opts)
+	query := `SELECT schema, name, kind, is_partition, row_estimate FROM (
+			SELECT
+				n.nspname::text AS schema,
+				c.relname::text AS name,
+				CASE c.relkind
+					WHEN 'r' THEN 'table'
+					WHEN 'p' THEN 'partitioned table'
+					WHEN 'v' THEN 'view'
+					WHEN 'm' THEN 'materialized view'
+					WHEN 'f' THEN 'foreign table'
+				END AS kind,
+				c.relispartition AS is_partition,
+				GREATEST(c.reltuples, 0)::bigint AS row_estimate
+			FROM pg_catalog.pg_class c
+			JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f') AND ` + userSchemas + `
+		) r
+		WHERE ($1::text[] IS NULL OR schema = ANY($1))
+		AND ($2::text[] IS NULL OR kind = ANY($2))
+		ORDER BY schema, name`
+	tables := []Table{}
+	err := queryAll(ctx, q, query, func(rows pgx.Rows) error {
+		var t Table
+		var kind string
+		if err := rows.Scan(&t.Schema, &t.Name, &kind, &t.IsPartition, &t.RowEstimate); err != nil {
+			return err
+		}
+		t.Kind = RelationKind(kind)
+		tables = append(tables, t)
+		return nil
+	}, cfg.schemaFilter(), cfg.kindFilter())
+	if err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+const columnsQuery = `SELECT
+		a.attname::text,
+		pg_catalog.format_type(a.atttypid, NULL),
+		t.typname::text,
+		a.atttypid,
+		NOT a.attnotnull,
+		COALESCE(pg_catalog.pg_get_expr(d.adbin, d.adrelid), ''),
+		a.attnum,
+		a.attidentity <> '',
+		a.attgenerated <> '',
+		CASE WHEN a.atttypid IN ('pg_catalog.bpchar'::regtype, 'pg_catalog.varchar'::regtype) AND a.atttypmod > 4 THEN a.atttypmod - 4 ELSE 0 END
+	FROM pg_catalog.pg_attribute a
+	JOIN pg_catalog.pg_class c ON c.oid = a.attrelid
+	JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+	JOIN pg_catalog.pg_type t ON t.oid = a.atttypid
+	LEFT JOIN pg_catalog.pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+	WHERE n.nspname = $1 AND c.relname = $2 AND a.attnum > 0 AND NOT a.attisdropped
+	ORDER BY a.attnum`
+
+// Columns describes the columns of a table, view or other relation, in ordinal order.
+func (f *fixture) Columns(ctx context.Context, database, schema, table string) ([]Column, error) {
+	db, err := f.Connect(ctx, ConnOptDatabase(database))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return tableColumns(ctx, db, schema, table)
+}
+
+func tableColumns(ctx context.Context, q Queryer, schema, table string) ([]Column, error) {
+	columns := []Column{}
+	err := queryAll(ctx, q, columnsQuery, func(rows pgx.Rows) error {
+		var c Column
+		if err := rows.Scan(&c.Name, &c.DataType, &c.UDTName, &c.TypeOID, &c.Nullable, &c.Default, &c.Position, &c.IsIdentity, &c.IsGenerated, &c.CharacterMaximumLength); err != nil {
+			return err
+		}
+		columns = append(columns, c)
+		return nil
+	}, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
+
+// Views lists views and materialized views.
+func (f *fixture) Views(ctx context.Context, database string, opts ...IntrospectOpt) ([]View, error) {
+	cfg := newIntrospectConfig(opts)
+	query := `SELECT n.nspname::text, c.relname::text, c.relkind = 'm', pg_catalog.pg_get_viewdef(c.oid, true)
+		FROM pg_catalog.pg_class c
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('v', 'm') AND ` + userSchemas + `
+		AND ($1::text[] IS NULL OR n.nspname = ANY($1))
+		ORDER BY 1, 2`
+	views := []View{}
+	err := f.introspect(ctx, database, query, func(rows pgx.Rows) error {
+		var v View
+		if err := rows.Scan(&v.Schema, &v.Name, &v.Materialized, &v.Definition); err != nil {
+			return err
+		}
+		views = append(views, v)
+		return nil
+	}, cfg.schemaFilter())
+	if err != nil {
+		return nil, err
+	}
+	return views, nil
+}
+
+func (f *fixture) Sequences(ctx context.Context, database string, opts ...IntrospectOpt) ([]Sequence, error) {
+	db, err := f.Connect(ctx, ConnOptDatabase(database))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return sequences(ctx, db, opts...)
+}
+
+func sequences(ctx context.Context, q Queryer, opts ...IntrospectOpt) ([]Sequence, error) {
+	cfg := newIntrospectConfig(opts)
+	query := `SELECT
+			n.nspname::text,
+			c.relname::text,
+			pg_catalog.format_type(s.seqtypid, NULL),
+			s.seqstart,
+			s.seqincrement,
+			COALESCE((
+				SELECT tn.nspname || '.' || t.relname || '.' || a.attname
+				FROM pg_catalog.pg_depend d
+				JOIN pg_catalog.pg_class t ON t.oid = d.refobjid
+				JOIN pg_catalog.pg_namespace tn ON tn.oid = t.relnamespace
+				JOIN pg_catalog.pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+				WHERE d.classid = 'pg_catalog.pg_class'::regclass
+				AND d.objid = c.oid
+				AND d.deptype IN ('a', 'i')
+				LIMIT 1
+			), '')
+		FROM pg_catalog.pg_sequence s
+		JOIN pg_catalog.pg_class c ON c.oid = s.seqrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE ` + userSchemas + `
+		AND ($1::text[] IS NULL OR n.nspname = ANY($1))
+		ORDER BY 1, 2`
+	out := []Sequence{}
+	err := queryAll(ctx, q, query, func(rows pgx.Rows) error {
+		var s Sequence
+		if err := rows.Scan(&s.Schema, &s.Name, &s.DataType, &s.Start, &s.Increment, &s.OwnedBy); err != nil {
+			return err
+		}
+		out = append(out, s)
+		return nil
+	}, cfg.schemaFilter())
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Functions lists functions, procedures, aggregates and window functions, excluding those installed by extensions.
+func (f *fixture) Functions(ctx context.Context, database string, opts ...IntrospectOpt) ([]Function, error) {
+	cfg := newIntrospectConfig(opts)
+	query := `SELECT
+			n.nspname::text,
+			p.proname::text,
+			pg_catalog.pg_get_function_identity_arguments(p.oid),
+			COALESCE(pg_catalog.pg_get_function_result(p.oid), ''),
+			CASE p.prokind
+				WHEN 'f' THEN 'function'
+				WHEN 'p' THEN 'procedure'
+				WHEN 'a' THEN 'aggregate'
+				WHEN 'w' THEN 'window'
+			END,
+			l.lanname::text,
+			CASE WHEN p.prokind IN ('f', 'p') THEN pg_catalog.pg_get_functiondef(p.oid) ELSE '' END
+		FROM pg_catalog.pg_proc p
+		JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+		JOIN pg_catalog.pg_language l ON l.oid = p.prolang
+		WHERE ` + userSchemas + `
+		AND NOT EXISTS (
+			SELECT 1 FROM pg_catalog.pg_depend d
+			WHERE d.classid = 'pg_catalog.pg_proc'::regclass AND d.objid = p.oid AND d.deptype = 'e'
+		)
+		AND ($1::text[] IS NULL OR n.nspname = ANY($1))
+		ORDER BY 1, 2, 3`
+	functions := []Function{}
+	err := f.introspect(ctx, database, query, func(rows pgx.Rows) error {
+		var fn Function
+		if err := rows.Scan(&fn.Schema, &fn.Name, &fn.Arguments, &fn.Result, &fn.Kind, &fn.Language, &fn.Definition); err != nil {
+			return err
+		}
+		functions = append(functions, fn)
+		return nil
+	}, cfg.schemaFilter())
+	if err != nil {
+		return nil, err
+	}
+	return functions, nil
+}
+
+func (f *fixture) Indexes(ctx context.Context, database string, opts ...IntrospectOpt) ([]Index, error) {
+	cfg := newIntrospectConfig(opts)
+	query := `SELECT n.nspname::text, t.relname::text, c.relname::text, i.indisunique, i.indisprimary, pg_catalog.pg_get_indexdef(i.indexrelid)
+		FROM pg_catalog.pg_index i
+		JOIN pg_catalog.pg_class c ON c.oid = i.indexrelid
+		JOIN pg_catalog.pg_class t ON t.oid = i.indrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE ` + userSchemas + `
+		AND ($1::text[] IS NULL OR n.nspname = ANY($1))
+		ORDER BY 1, 2, 3`
+	indexes := []Index{}
+	err := f.introspect(ctx, database, query, func(rows pgx.Rows) error {
+		var i Index
+		if err := rows.Scan(&i.Schema, &i.Table, &i.Name, &i.Unique, &i.Primary, &i.Definition); err != nil {
+			return err
+		}
+		indexes = append(indexes, i)
+		return nil
+	}, cfg.schemaFilter())
+	if err != nil {
+		return nil, err
+	}
+	return indexes, nil
+}
+
+// Constraints lists table constraints. Domain constraints are not included.
+func (f *fixture) Constraints(ctx context.Context, database string, opts ...IntrospectOpt) ([]Constraint, error) {
+	cfg := newIntrospectConfig(opts)
+	query := `SELECT
+			n.nspname::text,
+			t.relname::text,
+			c.conname::text,
+			CASE c.contype
+				WHEN 'p' THEN 'PRIMARY KEY'
+				WHEN 'f' THEN 'FOREIGN KEY'
+				WHEN 'u' THEN 'UNIQUE'
+				WHEN 'c' THEN 'CHECK'
+				WHEN 'x' THEN 'EXCLUDE'
+				WHEN 't' THEN 'TRIGGER'
+			END,
+			pg_catalog.pg_get_constraintdef(c.oid, true),
+			COALESCE(rn.nspname || '.' || r.relname, '')
+		FROM pg_catalog.pg_constraint c
+		JOIN pg_catalog.pg_class t ON t.oid = c.conrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = t.relnamespace
+		LEFT JOIN pg_catalog.pg_class r ON r.oid = c.confrelid
+		LEFT JOIN pg_catalog.pg_namespace rn ON rn.oid = r.relnamespace
+		WHERE ` + userSchemas + `
+		AND ($1::text[] IS NULL OR n.nspname = ANY($1))
+		ORDER BY 1, 2, 3`
+	constraints := []Constraint{}
+	err := f.introspect(ctx, database, query, func(rows pgx.Rows) error {
+		var c Constraint
+		if err := rows.Scan(&c.Schema, &c.Table, &c.Name, &c.Type, &c.Definition, &c.References); err != nil {
+			return err
+		}
+		constraints = append(constraints, c)
+		return nil
+	}, cfg.schemaFilter())
+	if err != nil {
+		return nil, err
+	}
+	return constraints, nil
+}
+
+// Triggers lists user-defined triggers. Internal triggers (e.g. those backing foreign keys) are not included.
+func (f *fixture) Triggers(ctx context.Context, database string, opts ...IntrospectOpt) ([]Trigger, error) {
+	cfg := newIntrospectConfig(opts)
+	query := `SELECT n.nspname::text, c.relname::text, t.tgname::text, t.tgenabled <> 'D', pg_catalog.pg_get_triggerdef(t.oid, true)
+		FROM pg_catalog.pg_trigger t
+		JOIN pg_catalog.pg_class c ON c.oid = t.tgrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE NOT t.tgisinternal AND ` + userSchemas + `
+		AND ($1::text[] IS NULL OR n.nspname = ANY($1))
+		ORDER BY 1, 2, 3`
+	triggers := []Trigger{}
+	err := f.introspect(ctx, database, query, func(rows pgx.Rows) error {
+		var t Trigger
+		if err := rows.Scan(&t.Schema, &t.Table, &t.Name, &t.Enabled, &t.Definition); err != nil {
+			return err
+		}
+		triggers = append(triggers, t)
+		return nil
+	}, cfg.schemaFilter())
+	if err != nil {
+		return nil, err
+	}
+	return triggers, nil
+}
+
+// Relation returns the table, view or other relation with the given name, or nil if it doesn't exist.
+func (f *fixture) Relation(ctx context.Context, database, schema, name string) (*Table, error) {
+	relations, err := f.Relations(ctx, database, IntrospectOptSchema(schema))
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range relations {
+		if r.Name == name {
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+// QueryColumns describes the result columns of a query without executing it. Nullability is only known for columns
+// which come directly from a table or view; all others are reported as nullable.
+func (f *fixture) QueryColumns(ctx context.Context, database, sql string) ([]Column, error) {
+	db, err := f.Connect(ctx, ConnOptDatabase(database))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	sd, err := conn.Conn().Prepare(ctx, "", sql)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare: %w", err)
+	}
+
+	columns := make([]Column, len(sd.Fields))
+	for i, fd := range sd.Fields {
+		columns[i] = Column{
+			Name:     string(fd.Name),
+			TypeOID:  fd.DataTypeOID,
+			Position: i + 1,
+			Nullable: true,
+		}
+		if fd.TableOID != 0 {
+			query := "SELECT NOT attnotnull FROM pg_catalog.pg_attribute WHERE attrelid = $1 AND attnum = $2"
+			if err := conn.QueryRow(ctx, query, fd.TableOID, int16(fd.TableAttributeNumber)).Scan(&columns[i].Nullable); err != nil {
+				return nil, fmt.Errorf("failed to query: %w", err)
+			}
+		}
+		query := "SELECT typname::text, pg_catalog.format_type(oid, NULL) FROM pg_catalog.pg_type WHERE oid = $1"
+		if err := conn.QueryRow(ctx, query, fd.DataTypeOID).Scan(&columns[i].UDTName, &columns[i].DataType); err != nil {
+			return nil, fmt.Errorf("failed to query: %w", err)
+		}
+	}
+	return columns, nil
+}
+
+// FunctionColumns describes the result columns of a function (e.g. one which RETURNS TABLE or SETOF a composite type).
+func (f *fixture) FunctionColumns(ctx context.Context, database, schema, name string) ([]Column, error) {
+	var nargs, found int
+	err := f.introspect(ctx, database, `SELECT p.pronargs
+		FROM pg_catalog.pg_proc p
+		JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace
+		WHERE n.nspname = $1 AND p.proname = $2`, func(rows pgx.Rows) error {
+		found++
+		return rows.Scan(&nargs)
+	}, schema, name)
+	if err != nil {
+		return nil, err
+	}
+	if found == 0 {
+		return nil, fmt.Errorf("function %v.%v does not exist", schema, name)
+	}
+	if found > 1 {
+		return nil, fmt.Errorf("function %v.%v is overloaded", schema, name)
+	}
+	params := make([]string, nargs)
+	for i := range params {
+		params[i] = fmt.Sprintf("$%v", i+1)
+	}
+	return f.QueryColumns(ctx, database, fmt.Sprintf("SELECT * FROM %v(%v)", pgx.Identifier{schema, name}.Sanitize(), strings.Join(params, ", ")))
+}
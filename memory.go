@@ -0,0 +1,68 @@
+package pgtest
+
+import (
+	"runtime"
+
+	"github.com/charlieparkes/go-fixtures/v2"
+)
+
+const (
+	minSharedBuffersMB = 32
+	maxSharedBuffersMB = 1024
+	minWorkMemMB       = 1
+	maxWorkMemMB       = 64
+
+	defaultParallelism = 4
+)
+
+// OptMemoryBudget sizes shared_buffers and work_mem for the memory available to the container, rather than the
+// memory of the whole host.
+//
+// budgetMB is the memory to size the settings for. It only tunes postgres and doesn't limit the container; use
+// OptMemoryLimit for that. If 0, the container's memory limit is used, or if there isn't one, the container is assumed
+// to share host memory equally with as many other containers as `go test` runs packages at once (GOMAXPROCS).
+//
+// parallelism is how many connections are expected to run queries at once (e.g. parallel tests sharing the
+// container), each of which may use work_mem for every sort or hash. Defaults to 4.
+//
+// A quarter of the budget goes to shared_buffers (between 32MB and 1GB) and another quarter is divided between
+// connections for work_mem (between 1MB and 64MB). Without this option, the same rules apply with the defaults.
+func OptMemoryBudget(budgetMB int, parallelism int) Opt {
+	return func(f *Postgres) {
+		if budgetMB > 0 {
+			f.budgetMB = budgetMB
+		}
+		f.parallelism = parallelism
+	}
+}
+
+// memorySettings returns shared_buffers and work_mem, in MB, for a memory budget.
+func memorySettings(budgetMB int, parallelism int) (int, int) {
+	if parallelism < 1 {
+		parallelism = defaultParallelism
+	}
+	sharedBuffers := clamp(budgetMB/4, minSharedBuffersMB, maxSharedBuffersMB)
+	workMem := clamp(budgetMB/4/parallelism, minWorkMemMB, maxWorkMemMB)
+	return sharedBuffers, workMem
+}
+
+// memoryBudget returns the memory, in MB, postgres can expect to have.
+func (f *fixture) memoryBudget() int {
+	if f.budgetMB > 0 {
+		return f.budgetMB
+	}
+	if f.memoryMB > 0 {
+		return f.memoryMB
+	}
+	return int(fixtures.MemoryMB()) / runtime.GOMAXPROCS(0)
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
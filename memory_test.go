@@ -0,0 +1,40 @@
+package pgtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemorySettings(t *testing.T) {
+	tests := []struct {
+		budgetMB      int
+		parallelism   int
+		sharedBuffers int
+		workMem       int
+	}{
+		// A 64GB host running 20 packages at once.
+		{budgetMB: 64000 / 20, parallelism: 4, sharedBuffers: 800, workMem: 64},
+		{budgetMB: 1024, parallelism: 4, sharedBuffers: 256, workMem: 64},
+		{budgetMB: 1024, parallelism: 16, sharedBuffers: 256, workMem: 16},
+		{budgetMB: 512, parallelism: 0, sharedBuffers: 128, workMem: 32},
+		{budgetMB: 64, parallelism: 100, sharedBuffers: 32, workMem: 1},
+		{budgetMB: 64000, parallelism: 1, sharedBuffers: 1024, workMem: 64},
+	}
+	for _, tt := range tests {
+		sharedBuffers, workMem := memorySettings(tt.budgetMB, tt.parallelism)
+		assert.Equal(t, tt.sharedBuffers, sharedBuffers, "shared_buffers for %vMB", tt.budgetMB)
+		assert.Equal(t, tt.workMem, workMem, "work_mem for %vMB / %v", tt.budgetMB, tt.parallelism)
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	p := &Postgres{}
+	OptMemoryBudget(512, 4)(p)
+	assert.Equal(t, 512, p.memoryBudget())
+	assert.Zero(t, p.memoryMB, "a budget should not limit the container")
+
+	p = &Postgres{}
+	OptMemoryLimit(256)(p)
+	assert.Equal(t, 256, p.memoryBudget())
+}
@@ -0,0 +1,167 @@
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charlieparkes/go-fixtures/v2"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.uber.org/zap"
+)
+
+// Migration is a pair of up and down files following the `{version}_{name}.up.sql` / `{version}_{name}.down.sql`
+// convention used by golang-migrate and similar tools.
+type Migration struct {
+	Version uint64
+	Name    string
+	// Up and Down are file paths. Down is empty if the migration has no down file.
+	Up   string
+	Down string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%v_%v", m.Version, m.Name)
+}
+
+// ReadMigrations finds the migrations in a directory, sorted by version.
+func ReadMigrations(dir string) ([]Migration, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[uint64]*Migration{}
+	for _, path := range files {
+		base := filepath.Base(path)
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		v, name, _ := strings.Cut(strings.TrimSuffix(base, "."+direction+".sql"), "_")
+		version, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %v: %w", base, err)
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = path
+		} else {
+			m.Down = path
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %v has no up file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// MigrationError reports a migration whose down file doesn't exactly reverse its up file.
+type MigrationError struct {
+	Migration Migration
+	// Diff is the difference between the schema before up was applied and after down was applied.
+	Diff *SchemaDiff
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("down migration %v does not reverse its up migration:\n%v", e.Migration, e.Diff)
+}
+
+// VerifyMigrations proves every down migration in dir exactly reverses its up migration. In a new, empty database,
+// each migration is applied up, the schema is snapshotted, then down is applied and the schema is compared with the
+// snapshot taken before up. The first lossy migration is reported as a *MigrationError. Finally up is re-applied (and
+// must reproduce the same schema) before moving on to the next migration.
+func (f *fixture) VerifyMigrations(ctx context.Context, dir string, opts ...IntrospectOpt) error {
+	migrations, err := ReadMigrations(dir)
+	if err != nil {
+		return err
+	}
+
+	database := fixtures.GetRandomName(0)
+	if err := f.CreateDatabase(ctx, database); err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.DropDatabase(ctx, database); err != nil {
+			f.log.Warn("failed to drop database", zap.String("database", database), zap.Error(err))
+		}
+	}()
+
+	db, err := f.Connect(ctx, ConnOptDatabase(database))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	before, err := f.Schema(ctx, database, opts...)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.Down == "" {
+			return fmt.Errorf("migration %v has no down file", m)
+		}
+		if err := applyFile(ctx, db, m.Up); err != nil {
+			return err
+		}
+		after, err := f.Schema(ctx, database, opts...)
+		if err != nil {
+			return err
+		}
+		if err := applyFile(ctx, db, m.Down); err != nil {
+			return err
+		}
+		reverted, err := f.Schema(ctx, database, opts...)
+		if err != nil {
+			return err
+		}
+		if diff := CompareSchemas(before, reverted); !diff.Empty() {
+			return &MigrationError{Migration: m, Diff: diff}
+		}
+		if err := applyFile(ctx, db, m.Up); err != nil {
+			return err
+		}
+		reapplied, err := f.Schema(ctx, database, opts...)
+		if err != nil {
+			return err
+		}
+		if diff := CompareSchemas(after, reapplied); !diff.Empty() {
+			return fmt.Errorf("migration %v produced a different schema when re-applied after down:\n%v", m, diff)
+		}
+		f.log.Debug("verify migration", zap.String("migration", m.String()), zap.String("database", database))
+		before = after
+	}
+	return nil
+}
+
+// applyFile executes a SQL file against db.
+func applyFile(ctx context.Context, db *pgxpool.Pool, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// Without arguments, pgx uses the simple protocol so files may contain multiple statements.
+	if _, err := db.Exec(ctx, string(b)); err != nil {
+		return fmt.Errorf("failed to apply %v: %w", filepath.Base(path), err)
+	}
+	return nil
+}
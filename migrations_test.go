@@ -0,0 +1,18 @@
+package pgtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadMigrations(t *testing.T) {
+	migrations, err := ReadMigrations("testdata/updown")
+	require.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{Version: 1, Name: "address", Up: "testdata/updown/1_address.up.sql", Down: "testdata/updown/1_address.down.sql"},
+		{Version: 2, Name: "person", Up: "testdata/updown/2_person.up.sql", Down: "testdata/updown/2_person.down.sql"},
+		{Version: 10, Name: "person_email", Up: "testdata/updown/10_person_email.up.sql", Down: "testdata/updown/10_person_email.down.sql"},
+	}, migrations)
+}
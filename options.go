@@ -1,6 +1,12 @@
 package pgtest
 
-import "go.uber.org/zap"
+import (
+	"fmt"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"go.uber.org/zap"
+)
 
 type Opt func(*Postgres)
 
@@ -65,3 +71,85 @@ func OptNetworkName(networkName string) Opt {
 		f.networkName = networkName
 	}
 }
+
+// OptTmpfs keeps the data directory on a tmpfs mount of sizeMB, so nothing is written to disk. If the container
+// runtime rejects tmpfs mounts, the container is started without it. Ignored with OptImageCache.
+func OptTmpfs(sizeMB int) Opt {
+	return func(f *Postgres) {
+		f.tmpfsMB = sizeMB
+	}
+}
+
+// OptRunOptions customizes the container's run options after pgtest has built them. Hooks run in order.
+func OptRunOptions(fn func(*dockertest.RunOptions)) Opt {
+	return func(f *Postgres) {
+		f.runOptions = append(f.runOptions, fn)
+	}
+}
+
+// OptHostConfig customizes the container's host config after pgtest has built it. Hooks run in order.
+func OptHostConfig(fn func(*docker.HostConfig)) Opt {
+	return func(f *Postgres) {
+		f.hostConfig = append(f.hostConfig, fn)
+	}
+}
+
+// OptMemoryLimit limits the container's memory (and swap) to sizeMB. Memory settings are derived from the limit; see
+// OptMemoryBudget.
+func OptMemoryLimit(sizeMB int) Opt {
+	return func(f *Postgres) {
+		f.memoryMB = sizeMB
+	}
+}
+
+// OptCPUs limits the container to a number of CPUs, e.g. 0.5 or 2.
+func OptCPUs(cpus float64) Opt {
+	return OptHostConfig(func(hc *docker.HostConfig) {
+		hc.CPUPeriod = 100000
+		hc.CPUQuota = int64(cpus * 100000)
+	})
+}
+
+// OptShmSize sets the size of /dev/shm, which postgres uses for parallel queries. Docker defaults to 64MB.
+func OptShmSize(sizeMB int) Opt {
+	return OptHostConfig(func(hc *docker.HostConfig) {
+		hc.ShmSize = int64(sizeMB) << 20
+	})
+}
+
+// OptLabels adds labels to the container.
+func OptLabels(labels map[string]string) Opt {
+	return OptRunOptions(func(opts *dockertest.RunOptions) {
+		if opts.Labels == nil {
+			opts.Labels = map[string]string{}
+		}
+		for k, v := range labels {
+			opts.Labels[k] = v
+		}
+	})
+}
+
+// OptEnv adds `KEY=value` environment variables to the container.
+func OptEnv(env ...string) Opt {
+	return OptRunOptions(func(opts *dockertest.RunOptions) {
+		opts.Env = append(opts.Env, env...)
+	})
+}
+
+// OptExposedPorts exposes additional container ports (e.g. `8080/tcp`).
+func OptExposedPorts(ports ...string) Opt {
+	return OptRunOptions(func(opts *dockertest.RunOptions) {
+		opts.ExposedPorts = append(opts.ExposedPorts, ports...)
+	})
+}
+
+// OptHostPort binds postgres to a fixed port on the host instead of a random one. Only one container can use it at a
+// time, so this is mostly useful for connecting other tools while debugging.
+func OptHostPort(port int) Opt {
+	return OptRunOptions(func(opts *dockertest.RunOptions) {
+		if opts.PortBindings == nil {
+			opts.PortBindings = map[docker.Port][]docker.PortBinding{}
+		}
+		opts.PortBindings["5432/tcp"] = []docker.PortBinding{{HostPort: fmt.Sprint(port)}}
+	})
+}
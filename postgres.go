@@ -5,12 +5,19 @@ import (
 	"fmt"
 
 	"github.com/charlieparkes/go-fixtures/v2"
+	"go.uber.org/zap"
 )
 
 type Postgres struct {
 	fixture
-	f           *fixtures.Fixtures
-	networkName string
+	f                 *fixtures.Fixtures
+	networkName       string
+	migrations        string
+	cacheDir          string
+	migrationsHash    string
+	restoredFromCache bool
+	imageCache        bool
+	cachedImage       bool
 }
 
 func NewPostgres(ctx context.Context, opts ...Opt) (*Postgres, error) {
@@ -28,6 +35,23 @@ func NewPostgres(ctx context.Context, opts ...Opt) (*Postgres, error) {
 	}
 	p.docker = p.f.Docker()
 
+	// Migrations
+	var migrations []string
+	if p.migrations != "" {
+		p.defaults()
+		var err error
+		if migrations, err = p.resolveMigrations(); err != nil {
+			p.abort(ctx)
+			return nil, fmt.Errorf("failed to read migrations: %w", err)
+		}
+		if p.imageCache {
+			if err := p.useCachedImage(); err != nil {
+				p.abort(ctx)
+				return nil, fmt.Errorf("failed to look up cached image: %w", err)
+			}
+		}
+	}
+
 	// Postgres
 	if err := p.f.Add(ctx, &p.fixture); err != nil {
 		return nil, fmt.Errorf("failed to setup postgres: %w", err)
@@ -37,9 +61,29 @@ func NewPostgres(ctx context.Context, opts ...Opt) (*Postgres, error) {
 	if err := p.Ping(ctx); err != nil {
 		return nil, fmt.Errorf("failed to ping postgres: %w", err)
 	}
+
+	// A cached image already contains the migrated database.
+	if p.migrations != "" && !p.cachedImage {
+		if err := p.migrate(ctx, migrations); err != nil {
+			p.abort(ctx)
+			return nil, fmt.Errorf("failed to migrate postgres: %w", err)
+		}
+		if p.imageCache {
+			if err := p.CommitImage(ctx, p.imageCacheTag()); err != nil {
+				p.log.Warn("failed to cache image", zap.Error(err))
+			}
+		}
+	}
 	return p, nil
 }
 
+// abort tears down whatever NewPostgres set up before failing, since the caller gets no handle to do it.
+func (p *Postgres) abort(ctx context.Context) {
+	if err := p.f.TearDown(ctx); err != nil {
+		p.log.Warn("failed to tear down", zap.Error(err))
+	}
+}
+
 func (p *Postgres) TearDown(ctx context.Context) error {
 	return p.f.TearDown(ctx)
 }
@@ -0,0 +1,66 @@
+package pgtest
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/charlieparkes/go-structs"
+)
+
+type registeredModel struct {
+	model interface{}
+	opts  []ValidateOpt
+}
+
+var registry struct {
+	sync.Mutex
+	models []registeredModel
+}
+
+// RegisterModel adds a struct to the set validated by ValidateRegisteredModels. Model packages typically call this
+// from init(), so new models are validated without updating a list in the tests.
+//
+//	func init() {
+//		pgtest.RegisterModel(&User{})
+//		pgtest.RegisterModel(&UserSummary{}, pgtest.ValidateOptQuery(userSummaryQuery))
+//	}
+func RegisterModel(model interface{}, opts ...ValidateOpt) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.models = append(registry.models, registeredModel{model: model, opts: opts})
+}
+
+// RegisteredModels returns every struct passed to RegisterModel.
+func RegisteredModels() []interface{} {
+	registry.Lock()
+	defer registry.Unlock()
+	models := make([]interface{}, len(registry.models))
+	for i, m := range registry.models {
+		models[i] = m.model
+	}
+	return models
+}
+
+// ValidateRegisteredModels runs ValidateModel for every registered struct as a subtest, so every failure is
+// reported rather than just the first. opts apply to every model, before any given to RegisterModel.
+func ValidateRegisteredModels(t *testing.T, f *Postgres, databaseName string, opts ...ValidateOpt) {
+	t.Helper()
+	registry.Lock()
+	models := append([]registeredModel{}, registry.models...)
+	registry.Unlock()
+
+	if len(models) == 0 {
+		t.Log("no models registered")
+		return
+	}
+	for _, m := range models {
+		m := m
+		t.Run(structs.Value(m.model).Type().String(), func(t *testing.T) {
+			modelOpts := append(append([]ValidateOpt{}, opts...), m.opts...)
+			if err := ValidateModel(context.Background(), f, databaseName, m.model, modelOpts...); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// SchemaObject is a single catalog object in a Schema snapshot.
+type SchemaObject struct {
+	// Kind is one of relation, column, index, constraint, sequence, view, function or trigger.
+	Kind string
+	// Name is the object's qualified name, e.g. public.person.first_name for a column.
+	Name       string
+	Definition string
+}
+
+// Schema is a deterministic snapshot of a database's schema, sorted by kind and name.
+type Schema struct {
+	Objects []SchemaObject
+}
+
+// String renders the schema as sorted, human-readable text which only changes when the schema does.
+func (s *Schema) String() string {
+	b := strings.Builder{}
+	for _, o := range s.Objects {
+		fmt.Fprintf(&b, "%v %v: %v\n", o.Kind, o.Name, indent(o.Definition))
+	}
+	return b.String()
+}
+
+func (s *Schema) sort() {
+	sort.Slice(s.Objects, func(i, j int) bool {
+		if s.Objects[i].Kind != s.Objects[j].Kind {
+			return schemaKindOrder[s.Objects[i].Kind] < schemaKindOrder[s.Objects[j].Kind]
+		}
+		return s.Objects[i].Name < s.Objects[j].Name
+	})
+}
+
+var schemaKindOrder = map[string]int{
+	"relation":   0,
+	"column":     1,
+	"constraint": 2,
+	"index":      3,
+	"sequence":   4,
+	"view":       5,
+	"function":   6,
+	"trigger":    7,
+}
+
+// indent continues multi-line definitions on indented lines, so every object starts at the beginning of a line.
+func indent(s string) string {
+	s = strings.TrimRight(s, "\n")
+	if !strings.Contains(s, "\n") {
+		return s
+	}
+	return "\n    " + strings.ReplaceAll(s, "\n", "\n    ")
+}
+
+// Schema snapshots the relations, columns, constraints, indexes, sequences, views, functions and triggers of a database.
+// database will default to the primary database
+func (f *fixture) Schema(ctx context.Context, database string, opts ...IntrospectOpt) (*Schema, error) {
+	cfg := newIntrospectConfig(opts)
+	schemaOpts := []IntrospectOpt{IntrospectOptSchema(cfg.schemas...)}
+	s := &Schema{}
+	add := func(kind, name, definition string) {
+		s.Objects = append(s.Objects, SchemaObject{Kind: kind, Name: name, Definition: definition})
+	}
+
+	relations, err := f.Relations(ctx, database, schemaOpts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range relations {
+		kind := string(r.Kind)
+		if r.IsPartition {
+			kind += " (partition)"
+		}
+		add("relation", r.String(), kind)
+	}
+
+	query := `SELECT
+			n.nspname::text || '.' || c.relname::text || '.' || a.attname::text,
+			pg_catalog.format_type(a.atttypid, a.atttypmod),
+			a.attnotnull,
+			COALESCE(pg_catalog.pg_get_expr(d.adbin, d.adrelid), ''),
+			a.attidentity::text,
+			a.attgenerated::text
+		FROM pg_catalog.pg_attribute a
+		JOIN pg_catalog.pg_class c ON c.oid = a.attrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_catalog.pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f') AND a.attnum > 0 AND NOT a.attisdropped AND ` + userSchemas + `
+		AND ($1::text[] IS NULL OR n.nspname = ANY($1))`
+	err = f.introspect(ctx, database, query, func(rows pgx.Rows) error {
+		var name, dataType, def, identity, generated string
+		var notNull bool
+		if err := rows.Scan(&name, &dataType, &notNull, &def, &identity, &generated); err != nil {
+			return err
+		}
+		definition := dataType
+		if notNull {
+			definition += " NOT NULL"
+		}
+		switch {
+		case generated != "":
+			definition += fmt.Sprintf(" GENERATED ALWAYS AS (%v) STORED", def)
+		case identity == "a":
+			definition += " GENERATED ALWAYS AS IDENTITY"
+		case identity == "d":
+			definition += " GENERATED BY DEFAULT AS IDENTITY"
+		case def != "":
+			definition += " DEFAULT " + def
+		}
+		add("column", name, definition)
+		return nil
+	}, cfg.schemaFilter())
+	if err != nil {
+		return nil, err
+	}
+
+	constraints, err := f.Constraints(ctx, database, schemaOpts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range constraints {
+		add("constraint", c.Schema+"."+c.Table+"."+c.Name, c.Definition)
+	}
+
+	indexes, err := f.Indexes(ctx, database, schemaOpts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range indexes {
+		add("index", i.Schema+"."+i.Name, i.Definition)
+	}
+
+	sequences, err := f.Sequences(ctx, database, schemaOpts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, seq := range sequences {
+		definition := fmt.Sprintf("%v START %v INCREMENT %v", seq.DataType, seq.Start, seq.Increment)
+		if seq.OwnedBy != "" {
+			definition += " OWNED BY " + seq.OwnedBy
+		}
+		add("sequence", seq.Schema+"."+seq.Name, definition)
+	}
+
+	views, err := f.Views(ctx, database, schemaOpts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range views {
+		add("view", v.Schema+"."+v.Name, strings.TrimSpace(v.Definition))
+	}
+
+	functions, err := f.Functions(ctx, database, schemaOpts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range functions {
+		definition := strings.TrimSpace(fn.Definition)
+		if definition == "" {
+			definition = fmt.Sprintf("%v RETURNS %v", fn.Kind, fn.Result)
+		}
+		add("function", fmt.Sprintf("%v.%v(%v)", fn.Schema, fn.Name, fn.Arguments), definition)
+	}
+
+	triggers, err := f.Triggers(ctx, database, schemaOpts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range triggers {
+		definition := t.Definition
+		if !t.Enabled {
+			definition += " (disabled)"
+		}
+		add("trigger", t.Schema+"."+t.Table+"."+t.Name, definition)
+	}
+
+	s.sort()
+	return s, nil
+}
+
+// SchemaChange is a single difference between two schemas. A is the object's definition in the first schema and B
+// its definition in the second; one of them is empty if the object only exists in the other schema.
+type SchemaChange struct {
+	Kind string
+	Name string
+	A    string
+	B    string
+}
+
+func (c SchemaChange) String() string {
+	switch {
+	case c.A == "":
+		return fmt.Sprintf("+ %v %v: %v", c.Kind, c.Name, indent(c.B))
+	case c.B == "":
+		return fmt.Sprintf("- %v %v: %v", c.Kind, c.Name, indent(c.A))
+	default:
+		return fmt.Sprintf("~ %v %v:\n  - %v\n  + %v", c.Kind, c.Name, strings.ReplaceAll(c.A, "\n", "\n    "), strings.ReplaceAll(c.B, "\n", "\n    "))
+	}
+}
+
+// SchemaDiff lists the differences between two schemas.
+type SchemaDiff struct {
+	Changes []SchemaChange
+}
+
+// Empty reports whether the schemas were identical.
+func (d *SchemaDiff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+// String renders the diff with one change per line: `-` for objects only in A, `+` for objects only in B, and `~`
+// for objects whose definitions differ.
+func (d *SchemaDiff) String() string {
+	lines := make([]string, len(d.Changes))
+	for i, c := range d.Changes {
+		lines[i] = c.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// CompareSchemas returns the differences between two schema snapshots.
+func CompareSchemas(a, b *Schema) *SchemaDiff {
+	key := func(o SchemaObject) string {
+		return o.Kind + " " + o.Name
+	}
+	inB := make(map[string]SchemaObject, len(b.Objects))
+	for _, o := range b.Objects {
+		inB[key(o)] = o
+	}
+	diff := &SchemaDiff{}
+	seen := map[string]bool{}
+	for _, o := range a.Objects {
+		seen[key(o)] = true
+		other, ok := inB[key(o)]
+		switch {
+		case !ok:
+			diff.Changes = append(diff.Changes, SchemaChange{Kind: o.Kind, Name: o.Name, A: o.Definition})
+		case o.Definition != other.Definition:
+			diff.Changes = append(diff.Changes, SchemaChange{Kind: o.Kind, Name: o.Name, A: o.Definition, B: other.Definition})
+		}
+	}
+	for _, o := range b.Objects {
+		if !seen[key(o)] {
+			diff.Changes = append(diff.Changes, SchemaChange{Kind: o.Kind, Name: o.Name, B: o.Definition})
+		}
+	}
+	sort.SliceStable(diff.Changes, func(i, j int) bool {
+		if diff.Changes[i].Kind != diff.Changes[j].Kind {
+			return schemaKindOrder[diff.Changes[i].Kind] < schemaKindOrder[diff.Changes[j].Kind]
+		}
+		return diff.Changes[i].Name < diff.Changes[j].Name
+	})
+	return diff
+}
+
+// DiffSchemas compares the schemas of two databases, e.g. a migrated database and one loaded from a reference
+// schema.sql. Empty database names default to the primary database.
+func (f *fixture) DiffSchemas(ctx context.Context, databaseA, databaseB string, opts ...IntrospectOpt) (*SchemaDiff, error) {
+	a, err := f.Schema(ctx, databaseA, opts...)
+	if err != nil {
+		return nil, err
+	}
+	b, err := f.Schema(ctx, databaseB, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return CompareSchemas(a, b), nil
+}
@@ -0,0 +1,32 @@
+package pgtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareSchemas(t *testing.T) {
+	a := &Schema{Objects: []SchemaObject{
+		{Kind: "relation", Name: "public.person", Definition: "table"},
+		{Kind: "column", Name: "public.person.id", Definition: "integer NOT NULL"},
+		{Kind: "column", Name: "public.person.name", Definition: "text"},
+	}}
+	b := &Schema{Objects: []SchemaObject{
+		{Kind: "relation", Name: "public.person", Definition: "table"},
+		{Kind: "column", Name: "public.person.id", Definition: "bigint NOT NULL"},
+		{Kind: "column", Name: "public.person.nickname", Definition: "text"},
+	}}
+	assert.True(t, CompareSchemas(a, a).Empty())
+	diff := CompareSchemas(a, b)
+	assert.Equal(t, []SchemaChange{
+		{Kind: "column", Name: "public.person.id", A: "integer NOT NULL", B: "bigint NOT NULL"},
+		{Kind: "column", Name: "public.person.name", A: "text"},
+		{Kind: "column", Name: "public.person.nickname", B: "text"},
+	}, diff.Changes)
+	assert.Equal(t, `~ column public.person.id:
+  - integer NOT NULL
+  + bigint NOT NULL
+- column public.person.name: text
++ column public.person.nickname: text`, diff.String())
+}
@@ -0,0 +1,297 @@
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Statement is a single SQL statement, optionally annotated with where it came from.
+type Statement struct {
+	SQL string
+	// Source is a `file:line` reference, or empty if unknown.
+	Source string
+}
+
+func (s Statement) String() string {
+	if s.Source != "" {
+		return s.Source
+	}
+	sql := strings.Join(strings.Fields(s.SQL), " ")
+	if len(sql) > 60 {
+		sql = sql[:57] + "..."
+	}
+	return strconv.Quote(sql)
+}
+
+// StatementError is a statement which failed to prepare.
+type StatementError struct {
+	Statement Statement
+	Err       error
+}
+
+func (e StatementError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Statement, e.Err)
+}
+
+func (e StatementError) Unwrap() error {
+	return e.Err
+}
+
+// StatementErrors reports every statement which failed to prepare.
+type StatementErrors []StatementError
+
+func (e StatementErrors) Error() string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "%v statement(s) failed to prepare:", len(e))
+	for _, err := range e {
+		b.WriteString("\n\t")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// CheckStatements prepares (but does not execute) each statement against a database, and reports syntax errors,
+// unknown tables and columns, and parameters whose types don't fit, all at once as StatementErrors.
+// Utility statements such as DDL are only checked for syntax.
+func CheckStatements(ctx context.Context, f *Postgres, databaseName string, stmts ...string) error {
+	statements := make([]Statement, len(stmts))
+	for i, s := range stmts {
+		statements[i] = Statement{SQL: s}
+	}
+	return checkStatements(ctx, f, databaseName, statements)
+}
+
+// CheckStatementFiles runs CheckStatements on every statement found by ReadStatements in the given paths.
+func CheckStatementFiles(ctx context.Context, f *Postgres, databaseName string, paths ...string) error {
+	statements := []Statement{}
+	for _, path := range paths {
+		s, err := ReadStatements(path)
+		if err != nil {
+			return err
+		}
+		statements = append(statements, s...)
+	}
+	return checkStatements(ctx, f, databaseName, statements)
+}
+
+func checkStatements(ctx context.Context, f *Postgres, databaseName string, statements []Statement) error {
+	db, err := f.Connect(ctx, ConnOptDatabase(databaseName))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	errs := StatementErrors{}
+	for _, s := range statements {
+		if _, err := conn.Conn().Prepare(ctx, "", s.SQL); err != nil {
+			errs = append(errs, StatementError{Statement: s, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// ReadStatements extracts statements from a .sql file, a .go file, or a directory containing either.
+// SQL files are split on semicolons (respecting quotes, comments and dollar-quoted bodies). From Go files, string
+// constants and variables whose value begins with a DML keyword (SELECT, INSERT, UPDATE, DELETE, WITH) are used.
+func ReadStatements(path string) ([]Statement, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		statements := []Statement{}
+		for _, pattern := range []string{"*.sql", "*.go"} {
+			files, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				s, err := ReadStatements(file)
+				if err != nil {
+					return nil, err
+				}
+				statements = append(statements, s...)
+			}
+		}
+		return statements, nil
+	}
+
+	switch filepath.Ext(path) {
+	case ".go":
+		return readGoStatements(path)
+	default:
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		statements := []Statement{}
+		for _, s := range splitStatements(string(b)) {
+			statements = append(statements, Statement{SQL: s.SQL, Source: fmt.Sprintf("%v:%v", path, s.Source)})
+		}
+		return statements, nil
+	}
+}
+
+var dmlPrefix = regexp.MustCompile(`(?is)^\s*(SELECT|INSERT|UPDATE|DELETE|WITH)\s`)
+
+func readGoStatements(path string) ([]Statement, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	statements := []Statement{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+		for _, v := range spec.Values {
+			sql, ok := stringLiteral(v)
+			if !ok || !dmlPrefix.MatchString(sql) {
+				continue
+			}
+			statements = append(statements, Statement{SQL: sql, Source: fset.Position(v.Pos()).String()})
+		}
+		return false
+	})
+	return statements, nil
+}
+
+// stringLiteral evaluates string literals and concatenations of them.
+func stringLiteral(expr ast.Expr) (string, bool) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return "", false
+		}
+		s, err := strconv.Unquote(e.Value)
+		return s, err == nil
+	case *ast.BinaryExpr:
+		if e.Op != token.ADD {
+			return "", false
+		}
+		x, ok := stringLiteral(e.X)
+		if !ok {
+			return "", false
+		}
+		y, ok := stringLiteral(e.Y)
+		return x + y, ok
+	case *ast.ParenExpr:
+		return stringLiteral(e.X)
+	}
+	return "", false
+}
+
+var dollarQuoteTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z_0-9]*)?\$`)
+
+// SplitStatements splits a string of SQL on semicolons, respecting quoted strings and identifiers, comments and
+// dollar-quoted bodies. Empty statements are dropped.
+func SplitStatements(sql string) []string {
+	statements := []string{}
+	for _, s := range splitStatements(sql) {
+		statements = append(statements, s.SQL)
+	}
+	return statements
+}
+
+// splitStatements returns statements with Source set to the line on which each starts.
+func splitStatements(sql string) []Statement {
+	statements := []Statement{}
+	start, line, startLine := 0, 1, 1
+	hasCode := false
+	flush := func(end int) {
+		if s := strings.TrimSpace(sql[start:end]); hasCode && s != "" {
+			statements = append(statements, Statement{SQL: s, Source: strconv.Itoa(startLine)})
+		}
+		hasCode = false
+	}
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\n':
+			line++
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			line++
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			depth := 0
+			for ; i < len(sql); i++ {
+				if strings.HasPrefix(sql[i:], "/*") {
+					depth++
+					i++
+				} else if strings.HasPrefix(sql[i:], "*/") {
+					depth--
+					i++
+					if depth == 0 {
+						break
+					}
+				} else if sql[i] == '\n' {
+					line++
+				}
+			}
+		case c == '\'' || c == '"':
+			if !hasCode {
+				startLine = line
+			}
+			hasCode = true
+			escapes := c == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e')
+			for i++; i < len(sql); i++ {
+				if sql[i] == '\n' {
+					line++
+				} else if escapes && sql[i] == '\\' {
+					i++
+				} else if sql[i] == c {
+					if i+1 < len(sql) && sql[i+1] == c {
+						i++
+						continue
+					}
+					break
+				}
+			}
+		case c == '$' && dollarQuoteTag.MatchString(sql[i:]):
+			if !hasCode {
+				startLine = line
+			}
+			hasCode = true
+			tag := dollarQuoteTag.FindString(sql[i:])
+			end := strings.Index(sql[i+len(tag):], tag)
+			if end < 0 {
+				i = len(sql)
+				break
+			}
+			body := sql[i : i+len(tag)+end+len(tag)]
+			line += strings.Count(body, "\n")
+			i += len(body) - 1
+		case c == ';':
+			flush(i)
+			start = i + 1
+		case c == ' ' || c == '\t' || c == '\r':
+		default:
+			if !hasCode {
+				startLine = line
+			}
+			hasCode = true
+		}
+	}
+	flush(len(sql))
+	return statements
+}
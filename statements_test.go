@@ -0,0 +1,26 @@
+package pgtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStatements(t *testing.T) {
+	sql := `-- leading comment
+SELECT 1;
+SELECT 'a;b', "c;d", E'e\';f';
+
+/* block; /* nested; */ comment */
+CREATE FUNCTION f() RETURNS int AS $body$
+	SELECT 1;
+$body$ LANGUAGE sql;
+-- trailing comment;
+`
+	statements := splitStatements(sql)
+	assert.Equal(t, []Statement{
+		{SQL: "-- leading comment\nSELECT 1", Source: "2"},
+		{SQL: `SELECT 'a;b', "c;d", E'e\';f'`, Source: "3"},
+		{SQL: "/* block; /* nested; */ comment */\nCREATE FUNCTION f() RETURNS int AS $body$\n\tSELECT 1;\n$body$ LANGUAGE sql", Source: "6"},
+	}, statements)
+}
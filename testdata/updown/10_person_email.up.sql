@@ -0,0 +1 @@
+ALTER TABLE person ADD COLUMN email TEXT;
@@ -0,0 +1,4 @@
+CREATE TABLE address (
+    id SERIAL PRIMARY KEY,
+    city TEXT
+);
@@ -0,0 +1,6 @@
+CREATE TABLE person (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    address_id INT REFERENCES address
+);
+CREATE INDEX person_name_idx ON person (name);
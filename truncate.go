@@ -0,0 +1,104 @@
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"go.uber.org/zap"
+)
+
+type truncateConfig struct {
+	exclude        []string
+	schemas        []string
+	resetSequences bool
+}
+
+type TruncateOpt func(*truncateConfig)
+
+// TruncateOptExclude skips the given tables. Names may be bare (`schema_migrations`) or schema-qualified
+// (`public.schema_migrations`); bare names match in every schema.
+func TruncateOptExclude(tables ...string) TruncateOpt {
+	return func(c *truncateConfig) {
+		c.exclude = append(c.exclude, tables...)
+	}
+}
+
+// TruncateOptSchemas limits truncation to tables in the given schemas. Defaults to all user schemas.
+func TruncateOptSchemas(schemas ...string) TruncateOpt {
+	return func(c *truncateConfig) {
+		c.schemas = append(c.schemas, schemas...)
+	}
+}
+
+// TruncateOptResetSequences also resets sequences which aren't owned by a truncated table's column (those are
+// already handled by RESTART IDENTITY), so standalone sequences start from the beginning again.
+func TruncateOptResetSequences() TruncateOpt {
+	return func(c *truncateConfig) {
+		c.resetSequences = true
+	}
+}
+
+func (c *truncateConfig) excluded(schema, table string) bool {
+	for _, e := range c.exclude {
+		if e == table || e == schema+"."+table {
+			return true
+		}
+	}
+	return false
+}
+
+// Truncate empties every user table in a database using a single `TRUNCATE ... RESTART IDENTITY`.
+// This is much cheaper than re-cloning a database between tests, and is safe to call from t.Cleanup.
+// It doesn't cascade, so Postgres rejects the truncate if a table which isn't being truncated (because it's excluded or
+// in another schema) has a foreign key to one which is, rather than emptying it anyway.
+// database will default to the primary database
+func (f *fixture) Truncate(ctx context.Context, database string, opts ...TruncateOpt) error {
+	cfg := &truncateConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	db, err := f.Connect(ctx, ConnOptDatabase(database))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tables, err := relations(ctx, db, IntrospectOptSchema(cfg.schemas...), IntrospectOptKind(RelationTable, RelationPartitionedTable))
+	if err != nil {
+		return err
+	}
+	identifiers := []string{}
+	for _, t := range tables {
+		if cfg.excluded(t.Schema, t.Name) {
+			continue
+		}
+		identifiers = append(identifiers, pgx.Identifier{t.Schema, t.Name}.Sanitize())
+	}
+
+	if len(identifiers) > 0 {
+		if _, err := db.Exec(ctx, fmt.Sprintf("TRUNCATE %v RESTART IDENTITY", strings.Join(identifiers, ", "))); err != nil {
+			return fmt.Errorf("failed to truncate: %w", err)
+		}
+	}
+
+	if cfg.resetSequences {
+		sequences, err := sequences(ctx, db, IntrospectOptSchema(cfg.schemas...))
+		if err != nil {
+			return err
+		}
+		for _, seq := range sequences {
+			if seq.OwnedBy != "" || cfg.excluded(seq.Schema, seq.Name) {
+				continue
+			}
+			if _, err := db.Exec(ctx, fmt.Sprintf("ALTER SEQUENCE %v RESTART", pgx.Identifier{seq.Schema, seq.Name}.Sanitize())); err != nil {
+				return fmt.Errorf("failed to reset sequence %v.%v: %w", seq.Schema, seq.Name, err)
+			}
+		}
+	}
+
+	f.log.Debug("truncate database", zap.String("database", db.Config().ConnConfig.Database), zap.Int("tables", len(identifiers)), zap.String("container", f.HostName()))
+	return nil
+}
@@ -2,7 +2,10 @@ package pgtest
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"regexp"
 	"strings"
 
 	"github.com/charlieparkes/go-structs"
@@ -13,77 +16,428 @@ type model interface {
 	TableName() string
 }
 
+type ValidateStrictness int
+
+const (
+	// ValidateStrictnessCompatible reports field types which can't be scanned from their column at all (e.g. a string
+	// field on an integer column), or which may overflow when scanned (e.g. an int32 field on a bigint column).
+	ValidateStrictnessCompatible ValidateStrictness = iota
+	// ValidateStrictnessExact additionally requires field types to match their column exactly: integer widths must
+	// match, time.Time requires timestamptz, string requires a text type (not uuid, json, etc.) and nullable columns
+	// require a pointer, sql.Null* or pgtype field.
+	ValidateStrictnessExact
+	// ValidateStrictnessNames only checks that columns exist.
+	ValidateStrictnessNames
+)
+
+type validateConfig struct {
+	strictness        ValidateStrictness
+	requireAllColumns bool
+	requireNotNull    bool
+	ignoreDefaulted   bool
+	writeOverflow     bool
+	query             string
+	function          string
+	tagName           string
+	namer             Namer
+}
+
+type ValidateOpt func(*validateConfig)
+
+func newValidateConfig(opts []ValidateOpt) *validateConfig {
+	cfg := &validateConfig{
+		tagName: "db",
+		namer:   SnakeCase,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+func ValidateOptStrictness(strictness ValidateStrictness) ValidateOpt {
+	return func(c *validateConfig) {
+		c.strictness = strictness
+	}
+}
+
+// ValidateOptRequireAllColumns reports table columns which aren't mapped by any struct field.
+func ValidateOptRequireAllColumns() ValidateOpt {
+	return func(c *validateConfig) {
+		c.requireAllColumns = true
+	}
+}
+
+// ValidateOptRequireNotNullColumns reports NOT NULL columns without a default, identity or generated value which aren't
+// mapped by any struct field. Inserting the struct into such a table will always fail.
+func ValidateOptRequireNotNullColumns() ValidateOpt {
+	return func(c *validateConfig) {
+		c.requireNotNull = true
+	}
+}
+
+// ValidateOptIgnoreDefaults excludes columns with a default, identity or generated value from ValidateOptRequireAllColumns.
+func ValidateOptIgnoreDefaults() ValidateOpt {
+	return func(c *validateConfig) {
+		c.ignoreDefaulted = true
+	}
+}
+
+// ValidateOptWriteOverflow also reports integer fields wider than their column (e.g. an int64 field on an integer
+// column), since writing a value outside the column's range fails. Under ValidateStrictnessExact these are always
+// reported.
+func ValidateOptWriteOverflow() ValidateOpt {
+	return func(c *validateConfig) {
+		c.writeOverflow = true
+	}
+}
+
+// ValidateOptTagName reads column names from the given struct tag instead of `db`. The gorm tag's `column:`, `-`,
+// `embedded` and `embeddedPrefix:` settings are understood; other tags use the db/sqlx `name,opts...` convention.
+func ValidateOptTagName(tagName string) ValidateOpt {
+	return func(c *validateConfig) {
+		c.tagName = tagName
+	}
+}
+
+// ValidateOptNamer derives table and column names from untagged struct and field names. Defaults to SnakeCase.
+func ValidateOptNamer(namer Namer) ValidateOpt {
+	return func(c *validateConfig) {
+		c.namer = namer
+	}
+}
+
+// ValidateOptQuery validates the struct against the result columns of a query, instead of its table. The query is
+// prepared but never executed.
+func ValidateOptQuery(sql string) ValidateOpt {
+	return func(c *validateConfig) {
+		c.query = sql
+	}
+}
+
+// ValidateOptFunction validates the struct against the result columns of a set-returning function (`schema.name`),
+// instead of its table. The function must not be overloaded.
+func ValidateOptFunction(name string) ValidateOpt {
+	return func(c *validateConfig) {
+		c.function = name
+	}
+}
+
+// FieldError describes a single mismatch between a struct field and a table column.
+type FieldError struct {
+	Field      string
+	Column     string
+	GoType     string
+	ColumnType string
+	Reason     string
+}
+
+func (e FieldError) String() string {
+	if e.Field == "" {
+		return fmt.Sprintf("(%v): %v", e.Column, e.Reason)
+	}
+	return fmt.Sprintf("%v (%v): %v", e.Field, e.Column, e.Reason)
+}
+
+// ValidationError reports every mismatch found between a struct and its table, view, function or query.
+type ValidationError struct {
+	Model string
+	// Source describes what the struct was validated against, e.g. `table public.person` or `query`.
+	Source string
+	// Schema and Table name the relation or function, and are empty for queries.
+	Schema string
+	Table  string
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "struct %v does not match %v:", e.Model, e.Source)
+	for _, f := range e.Fields {
+		b.WriteString("\n\t")
+		b.WriteString(f.String())
+	}
+	return b.String()
+}
+
 // ValidateModels checks that the given structs are valid representations of a database tables.
-//     1. Validate table name (using gorm-style TableName() or name-to-snake)
-//     2. Validate columns exist.
+//  1. Validate table name (using gorm-style TableName() or name-to-snake)
+//  2. Validate columns exist.
+//  3. Validate field types are compatible with column types.
 func ValidateModels(ctx context.Context, f *Postgres, databaseName string, i ...interface{}) error {
-	for _, iface := range i {
-		if err := ValidateModel(ctx, f, databaseName, iface); err != nil {
+	return ValidateModelsWithOpts(ctx, f, databaseName, i)
+}
+
+// ValidateModelsWithOpts is ValidateModels with options which apply to every model.
+func ValidateModelsWithOpts(ctx context.Context, f *Postgres, databaseName string, models []interface{}, opts ...ValidateOpt) error {
+	for _, iface := range models {
+		if err := ValidateModel(ctx, f, databaseName, iface, opts...); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// ValidateModel checks that a given struct is a valid representation of a database table.
-//     1. Validate table name (using gorm-style TableName() or name-to-snake)
-//     2. Validate columns exist.
-func ValidateModel(ctx context.Context, f *Postgres, databaseName string, i interface{}) error {
-	var tableName string
-	switch v := i.(type) {
-	case model:
-		tableName = strings.Trim(v.TableName(), "\"")
+// ValidateModel checks that a given struct is a valid representation of a database table, view, or other relation.
+//  1. Validate table name (using gorm-style TableName() or name-to-snake)
+//  2. Validate columns exist.
+//  3. Validate field types are compatible with column types.
+//  4. Optionally, validate that every (required) column is mapped by a field.
+//
+// All field mismatches are reported at once as a *ValidationError.
+func ValidateModel(ctx context.Context, f *Postgres, databaseName string, i interface{}, opts ...ValidateOpt) error {
+	cfg := newValidateConfig(opts)
+
+	verr := &ValidationError{Model: structs.Name(i)}
+	var columns []Column
+	var err error
+	switch {
+	case cfg.query != "":
+		verr.Source = "query"
+		columns, err = f.QueryColumns(ctx, databaseName, cfg.query)
+		if err != nil {
+			return err
+		}
+	case cfg.function != "":
+		verr.Schema, verr.Table = splitQualifiedName(cfg.function)
+		verr.Source = fmt.Sprintf("function %v.%v", verr.Schema, verr.Table)
+		columns, err = f.FunctionColumns(ctx, databaseName, verr.Schema, verr.Table)
+		if err != nil {
+			return err
+		}
 	default:
-		tableName = strcase.ToSnake(structs.Name(v))
+		verr.Schema, verr.Table = modelTable(i, cfg)
+
+		relation, err := f.Relation(ctx, databaseName, verr.Schema, verr.Table)
+		if err != nil {
+			return err
+		}
+		if relation == nil {
+			return fmt.Errorf("table %v.%v does not exist", verr.Schema, verr.Table)
+		}
+		verr.Source = fmt.Sprintf("%v %v", relation.Kind, relation)
+
+		columns, err = f.Columns(ctx, databaseName, verr.Schema, verr.Table)
+		if err != nil {
+			return err
+		}
+	}
+
+	columnsByName := make(map[string]Column, len(columns))
+	columnNames := make([]string, len(columns))
+	for i, c := range columns {
+		if _, ok := columnsByName[c.Name]; ok {
+			verr.Fields = append(verr.Fields, FieldError{
+				Column:     c.Name,
+				ColumnType: c.UDTName,
+				Reason:     "column is returned more than once",
+			})
+		}
+		columnsByName[c.Name] = c
+		columnNames[i] = c.Name
+	}
+
+	fields := modelFields(i, cfg)
+	mapped := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		mapped[field.Column] = true
+		c, ok := columnsByName[field.Column]
+		if !ok {
+			verr.Fields = append(verr.Fields, FieldError{
+				Field:  field.Name,
+				Column: field.Column,
+				GoType: field.Type.String(),
+				Reason: fmt.Sprintf("column does not exist in %v", columnNames),
+			})
+			continue
+		}
+		if reason := checkFieldType(field.Type, c, cfg.strictness, cfg.writeOverflow); reason != "" {
+			verr.Fields = append(verr.Fields, FieldError{
+				Field:      field.Name,
+				Column:     field.Column,
+				GoType:     field.Type.String(),
+				ColumnType: c.UDTName,
+				Reason:     reason,
+			})
+		}
+	}
+	for _, c := range columns {
+		if mapped[c.Name] {
+			continue
+		}
+		switch {
+		case cfg.requireAllColumns && !(cfg.ignoreDefaulted && c.HasDefault()):
+			verr.Fields = append(verr.Fields, FieldError{
+				Column:     c.Name,
+				ColumnType: c.UDTName,
+				Reason:     "column is not mapped by any field",
+			})
+		case cfg.requireNotNull && !c.Nullable && !c.HasDefault():
+			verr.Fields = append(verr.Fields, FieldError{
+				Column:     c.Name,
+				ColumnType: c.UDTName,
+				Reason:     "NOT NULL column without a default is not mapped by any field",
+			})
+		}
+	}
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// splitQualifiedName splits an optionally schema-qualified and quoted name, defaulting to the public schema.
+func splitQualifiedName(name string) (string, string) {
+	if s, n, found := strings.Cut(name, "."); found {
+		return strings.Trim(s, "\""), strings.Trim(n, "\"")
+	}
+	return "public", strings.Trim(name, "\"")
+}
+
+// modelTable returns the schema and table a struct maps to, using gorm-style TableName() or the namer.
+func modelTable(i interface{}, cfg *validateConfig) (string, string) {
+	if m, ok := i.(model); ok {
+		return splitQualifiedName(m.TableName())
 	}
+	return splitQualifiedName(cfg.namer(structs.Name(i)))
+}
+
+// Namer converts a Go struct or field name to a table or column name.
+type Namer func(name string) string
+
+var pluralInitialism = regexp.MustCompile(`([A-Z]{2,})s([A-Z]|$)`)
+
+// SnakeCase is the default Namer. It behaves like strcase.ToSnake, but keeps pluralized initialisms together
+// (e.g. UserIDs -> user_ids rather than user_i_ds).
+func SnakeCase(name string) string {
+	return strcase.ToSnake(pluralInitialism.ReplaceAllString(name, "${1}S$2"))
+}
 
-	var schemaName string = "public"
-	if s, t, found := strings.Cut(tableName, "."); found {
-		schemaName = strings.Trim(s, "\"")
-		tableName = strings.Trim(t, "\"")
+// ValidateQuery checks that the result of a query can be scanned into dest, which may be a struct or a slice of
+// structs. The query is prepared against the database but never executed, so no data is required. Result columns
+// which are missing from the struct, fields which are missing from the result, and mismatched types are all
+// reported in a *ValidationError.
+func ValidateQuery(ctx context.Context, f *Postgres, databaseName string, sql string, dest interface{}, opts ...ValidateOpt) error {
+	if dest == nil {
+		return errors.New("dest must be a struct or slice of structs, got nil")
 	}
+	t := reflect.TypeOf(dest)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("dest must be a struct or slice of structs, got %T", dest)
+	}
+	opts = append([]ValidateOpt{ValidateOptQuery(sql), ValidateOptRequireAllColumns()}, opts...)
+	return ValidateModel(ctx, f, databaseName, reflect.New(t).Interface(), opts...)
+}
 
-	exists, err := f.TableExists(ctx, databaseName, schemaName, tableName)
-	if err != nil {
-		return err
+type modelField struct {
+	// Name is the field's path from the top-level struct, e.g. BaseModel.ID
+	Name   string
+	Column string
+	Type   reflect.Type
+	// Index is the field's index sequence for reflect.Value.FieldByIndex.
+	Index []int
+}
+
+// fieldTag parses a struct tag into a column name, and whether the field should be skipped or flattened into
+// its parent. Tags other than gorm use the `name,opt,...` convention of db and sqlx.
+func fieldTag(f reflect.StructField, tagName string) (column string, skip bool, embed bool, prefix string) {
+	tag, ok := f.Tag.Lookup(tagName)
+	if !ok {
+		return "", false, false, ""
 	}
-	if !exists {
-		return fmt.Errorf("table %v.%v does not exist", schemaName, tableName)
+	if tagName == "gorm" {
+		for _, part := range strings.Split(tag, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(part), ":")
+			switch strings.ToLower(k) {
+			case "-":
+				if v == "" || v == "all" || v == "migration" {
+					skip = true
+				}
+			case "column":
+				column = v
+			case "embedded":
+				embed = true
+			case "embeddedprefix":
+				prefix = v
+			}
+		}
+		return column, skip, embed, prefix
 	}
+	column, _, _ = strings.Cut(tag, ",")
+	return column, column == "-", false, ""
+}
 
-	fieldNames := Columns(i)
-	columnNames, err := f.TableColumns(ctx, databaseName, schemaName, tableName)
-	if err != nil {
-		return err
+// flattenable reports whether an embedded field of type t should have its fields promoted rather than be treated
+// as a column.
+func flattenable(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
 	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return false
+	}
+	return !reflect.PtrTo(t).Implements(scannerType)
+}
 
-	for _, f := range fieldNames {
-		found := false
-		for _, c := range columnNames {
-			if f == c {
-				found = true
-				break
+// modelFields returns the struct's fields which map to columns. Embedded structs are flattened, and fields shadow
+// deeper fields with the same column name.
+func modelFields(i interface{}, cfg *validateConfig) []modelField {
+	fields := []modelField{}
+	seen := map[string]bool{}
+	var walk func(t reflect.Type, path string, index []int, prefix string)
+	walk = func(t reflect.Type, path string, index []int, prefix string) {
+		type embedded struct {
+			t      reflect.Type
+			path   string
+			index  []int
+			prefix string
+		}
+		deferred := []embedded{}
+		for n := 0; n < t.NumField(); n++ {
+			f := t.Field(n)
+			idx := append(append([]int{}, index...), n)
+			column, skip, embed, embedPrefix := fieldTag(f, cfg.tagName)
+			if skip {
+				continue
+			}
+			if (f.Anonymous && column == "" || embed) && flattenable(f.Type) {
+				ft := f.Type
+				for ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				deferred = append(deferred, embedded{ft, path + f.Name + ".", idx, prefix + embedPrefix})
+				continue
+			}
+			// Unexported fields
+			if f.PkgPath != "" {
+				continue
 			}
+			if column == "" {
+				column = cfg.namer(f.Name)
+			}
+			column = prefix + column
+			if seen[column] {
+				continue
+			}
+			seen[column] = true
+			fields = append(fields, modelField{Name: path + f.Name, Column: column, Type: f.Type, Index: idx})
 		}
-		if !found {
-			return fmt.Errorf("struct %v contains field %v which does not exist in table: %v.%v{%v}", structs.Name(i), f, schemaName, tableName, columnNames)
+		for _, e := range deferred {
+			walk(e.t, e.path, e.index, e.prefix)
 		}
 	}
-	return nil
+	walk(structs.Value(i).Type(), "", nil, "")
+	return fields
 }
 
 // Given a struct, return the expected column names.
-func Columns(i interface{}) []string {
-	sf := structs.Fields(i)
+func Columns(i interface{}, opts ...ValidateOpt) []string {
 	fields := []string{}
-	for _, f := range sf {
-		if tag := f.Tag.Get("db"); tag == "-" {
-			continue
-		} else if tag == "" {
-			fields = append(fields, strcase.ToSnake(f.Name))
-		} else {
-			fields = append(fields, tag)
-		}
+	for _, f := range modelFields(i, newValidateConfig(opts)) {
+		fields = append(fields, f.Column)
 	}
 	return fields
 }
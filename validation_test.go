@@ -0,0 +1,102 @@
+package pgtest
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckFieldType(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      interface{}
+		column     Column
+		strictness ValidateStrictness
+		ok         bool
+	}{
+		{"int64 on int4 (reads can't overflow)", int64(0), Column{UDTName: "int4"}, ValidateStrictnessCompatible, true},
+		{"int64 on int4 exact", int64(0), Column{UDTName: "int4"}, ValidateStrictnessExact, false},
+		{"int32 on int8", int32(0), Column{UDTName: "int8"}, ValidateStrictnessCompatible, false},
+		{"string on int4", "", Column{UDTName: "int4"}, ValidateStrictnessCompatible, false},
+		{"string on int4 names", "", Column{UDTName: "int4"}, ValidateStrictnessNames, true},
+		{"string on uuid", "", Column{UDTName: "uuid"}, ValidateStrictnessCompatible, true},
+		{"string on uuid exact", "", Column{UDTName: "uuid"}, ValidateStrictnessExact, false},
+		{"string on enum exact", "", Column{UDTName: "mood"}, ValidateStrictnessExact, true},
+		{"time on timestamp", time.Time{}, Column{UDTName: "timestamp"}, ValidateStrictnessCompatible, true},
+		{"time on timestamp exact", time.Time{}, Column{UDTName: "timestamp"}, ValidateStrictnessExact, false},
+		{"time on text", time.Time{}, Column{UDTName: "text"}, ValidateStrictnessCompatible, false},
+		{"string on nullable exact", "", Column{UDTName: "text", Nullable: true}, ValidateStrictnessExact, false},
+		{"*string on nullable exact", new(string), Column{UDTName: "text", Nullable: true}, ValidateStrictnessExact, true},
+		{"sql.NullInt64 on nullable exact", sql.NullInt64{}, Column{UDTName: "int8", Nullable: true}, ValidateStrictnessExact, true},
+		{"pgtype.Int4 on int4 exact", pgtype.Int4{}, Column{UDTName: "int4", Nullable: true}, ValidateStrictnessExact, true},
+		{"pgtype.Text on int4", pgtype.Text{}, Column{UDTName: "int4"}, ValidateStrictnessCompatible, false},
+		{"[]string on _text", []string{}, Column{UDTName: "_text"}, ValidateStrictnessCompatible, true},
+		{"[]int32 on _int8", []int32{}, Column{UDTName: "_int8"}, ValidateStrictnessCompatible, false},
+		{"map on jsonb", map[string]interface{}{}, Column{UDTName: "jsonb"}, ValidateStrictnessCompatible, true},
+		{"[]byte on bytea", []byte{}, Column{UDTName: "bytea"}, ValidateStrictnessExact, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := checkFieldType(reflect.TypeOf(tt.value), tt.column, tt.strictness, false)
+			if tt.ok {
+				assert.Empty(t, reason)
+			} else {
+				assert.NotEmpty(t, reason)
+			}
+		})
+	}
+}
+
+func TestCheckFieldTypeWriteOverflow(t *testing.T) {
+	assert.NotEmpty(t, checkFieldType(reflect.TypeOf(int64(0)), Column{UDTName: "int4"}, ValidateStrictnessCompatible, true))
+	assert.NotEmpty(t, checkFieldType(reflect.TypeOf([]int{}), Column{UDTName: "_int2"}, ValidateStrictnessCompatible, true))
+	assert.Empty(t, checkFieldType(reflect.TypeOf(int32(0)), Column{UDTName: "int4"}, ValidateStrictnessCompatible, true))
+	assert.Empty(t, checkFieldType(reflect.TypeOf(int64(0)), Column{UDTName: "int4"}, ValidateStrictnessCompatible, false))
+}
+
+type baseModel struct {
+	ID        int64
+	CreatedAt time.Time
+}
+
+type Author struct {
+	Name string
+}
+
+type Post struct {
+	baseModel
+	UserIDs   []int64
+	Title     string `db:"headline,omitempty"`
+	Body      string `gorm:"column:content"`
+	Author    Author `gorm:"embedded;embeddedPrefix:author_"`
+	Ignored   bool   `db:"-" gorm:"-"`
+	CreatedAt time.Time
+}
+
+func TestColumns(t *testing.T) {
+	assert.Equal(t, []string{"user_ids", "headline", "body", "author", "created_at", "id"}, Columns(&Post{}))
+	assert.Equal(t, []string{"user_ids", "title", "content", "created_at", "id", "author_name"}, Columns(&Post{}, ValidateOptTagName("gorm")))
+	assert.Equal(t, []string{"UserIDs", "headline", "Body", "Author", "CreatedAt", "ID"}, Columns(&Post{}, ValidateOptNamer(func(s string) string { return s })))
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, expected := range map[string]string{
+		"UserID":     "user_id",
+		"UserIDs":    "user_ids",
+		"URLsByHost": "urls_by_host",
+		"AddressId":  "address_id",
+		"HTTPServer": "http_server",
+	} {
+		assert.Equal(t, expected, SnakeCase(name), name)
+	}
+}
+
+func TestValidateQueryNilDest(t *testing.T) {
+	assert.Error(t, ValidateQuery(context.Background(), nil, "", "SELECT 1", nil))
+	assert.Error(t, ValidateQuery(context.Background(), nil, "", "SELECT 1", new(int)))
+}
@@ -0,0 +1,234 @@
+package pgtest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	rawJSONType  = reflect.TypeOf(json.RawMessage{})
+	scannerType  = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+// sqlNullTypes maps database/sql's nullable wrappers to the type they wrap.
+var sqlNullTypes = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(sql.NullString{}):  reflect.TypeOf(""),
+	reflect.TypeOf(sql.NullBool{}):    reflect.TypeOf(false),
+	reflect.TypeOf(sql.NullByte{}):    reflect.TypeOf(byte(0)),
+	reflect.TypeOf(sql.NullInt16{}):   reflect.TypeOf(int16(0)),
+	reflect.TypeOf(sql.NullInt32{}):   reflect.TypeOf(int32(0)),
+	reflect.TypeOf(sql.NullInt64{}):   reflect.TypeOf(int64(0)),
+	reflect.TypeOf(sql.NullFloat64{}): reflect.TypeOf(float64(0)),
+	reflect.TypeOf(sql.NullTime{}):    timeType,
+}
+
+// typeFamilies groups postgres types (by udt name) which the same Go types can generally be scanned into.
+var typeFamilies = map[string]string{
+	"int2":        "integer",
+	"int4":        "integer",
+	"int8":        "integer",
+	"oid":         "integer",
+	"numeric":     "numeric",
+	"float4":      "float",
+	"float8":      "float",
+	"text":        "text",
+	"varchar":     "text",
+	"bpchar":      "text",
+	"name":        "text",
+	"citext":      "text",
+	"uuid":        "uuid",
+	"bool":        "bool",
+	"timestamp":   "timestamp",
+	"timestamptz": "timestamp",
+	"date":        "date",
+	"time":        "time",
+	"timetz":      "time",
+	"interval":    "interval",
+	"json":        "json",
+	"jsonb":       "json",
+	"bytea":       "bytea",
+	"inet":        "network",
+	"cidr":        "network",
+	"macaddr":     "network",
+	"xml":         "xml",
+}
+
+var integerBits = map[string]int{
+	"int2": 16,
+	"int4": 32,
+	"int8": 64,
+}
+
+// goTypeExpectation describes which column types a Go type maps to.
+type goTypeExpectation struct {
+	// exact udt names accepted under ValidateStrictnessExact
+	exact []string
+	// type families accepted under ValidateStrictnessCompatible
+	families []string
+}
+
+func (e goTypeExpectation) acceptsFamily(family string) bool {
+	for _, f := range e.families {
+		if f == family {
+			return true
+		}
+	}
+	return false
+}
+
+func (e goTypeExpectation) acceptsExact(udt string) bool {
+	for _, u := range e.exact {
+		if u == udt {
+			return true
+		}
+	}
+	return false
+}
+
+// expectation returns the column types t can be stored in, or false if t isn't a type we can reason about
+// (e.g. interfaces or custom sql.Scanner implementations).
+func expectation(t reflect.Type) (goTypeExpectation, bool) {
+	switch t {
+	case timeType:
+		return goTypeExpectation{exact: []string{"timestamptz"}, families: []string{"timestamp", "date"}}, true
+	case durationType:
+		return goTypeExpectation{exact: []string{"interval"}, families: []string{"interval", "integer"}}, true
+	case rawJSONType:
+		return goTypeExpectation{exact: []string{"json", "jsonb"}, families: []string{"json"}}, true
+	}
+	if reflect.PtrTo(t).Implements(scannerType) {
+		return goTypeExpectation{}, false
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return goTypeExpectation{exact: []string{"bool"}, families: []string{"bool"}}, true
+	case reflect.String:
+		return goTypeExpectation{
+			exact:    []string{"text", "varchar", "bpchar", "citext", "name"},
+			families: []string{"text", "uuid", "json", "network", "xml", "numeric"},
+		}, true
+	case reflect.Int8, reflect.Uint8, reflect.Int16:
+		return goTypeExpectation{exact: []string{"int2"}, families: []string{"integer", "numeric"}}, true
+	case reflect.Uint16, reflect.Int32:
+		return goTypeExpectation{exact: []string{"int4"}, families: []string{"integer", "numeric"}}, true
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return goTypeExpectation{exact: []string{"int8"}, families: []string{"integer", "numeric"}}, true
+	case reflect.Float32:
+		return goTypeExpectation{exact: []string{"float4"}, families: []string{"float", "numeric"}}, true
+	case reflect.Float64:
+		return goTypeExpectation{exact: []string{"float8"}, families: []string{"float", "numeric"}}, true
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return goTypeExpectation{exact: []string{"bytea", "json", "jsonb"}, families: []string{"bytea", "json"}}, true
+		}
+		return goTypeExpectation{exact: []string{"json", "jsonb"}, families: []string{"json"}}, true
+	case reflect.Map, reflect.Struct:
+		return goTypeExpectation{exact: []string{"json", "jsonb"}, families: []string{"json"}}, true
+	}
+	return goTypeExpectation{}, false
+}
+
+// pgtypeUDT converts a github.com/jackc/pgtype type name to the udt name it represents, e.g. Int4Array -> _int4.
+func pgtypeUDT(name string) string {
+	udt := strings.ToLower(name)
+	if strings.HasSuffix(udt, "array") {
+		return "_" + strings.TrimSuffix(udt, "array")
+	}
+	return udt
+}
+
+// checkFieldType returns a reason the Go type t is unsuitable for column c, or an empty string. writeOverflow also
+// reports integer fields wider than their column (see ValidateOptWriteOverflow).
+func checkFieldType(t reflect.Type, c Column, strictness ValidateStrictness, writeOverflow bool) string {
+	if strictness == ValidateStrictnessNames {
+		return ""
+	}
+
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		nullable = true
+		t = t.Elem()
+	}
+	if inner, ok := sqlNullTypes[t]; ok {
+		nullable = true
+		t = inner
+	}
+
+	isPgtype := t.PkgPath() == "github.com/jackc/pgtype"
+	if strictness == ValidateStrictnessExact && c.Nullable && !nullable && !isPgtype {
+		return fmt.Sprintf("column is nullable but field type %v cannot hold NULL", t)
+	}
+
+	if isPgtype {
+		udt := pgtypeUDT(t.Name())
+		want, known := typeFamilies[strings.TrimPrefix(udt, "_")]
+		got, ok := typeFamilies[strings.TrimPrefix(c.UDTName, "_")]
+		if !known || !ok {
+			return ""
+		}
+		if strictness == ValidateStrictnessExact {
+			if udt != c.UDTName {
+				return fmt.Sprintf("field type %v does not match column type %v", t, c.UDTName)
+			}
+			return ""
+		}
+		if want != got || strings.HasPrefix(udt, "_") != strings.HasPrefix(c.UDTName, "_") {
+			return fmt.Sprintf("field type %v is incompatible with column type %v", t, c.UDTName)
+		}
+		return ""
+	}
+
+	// Arrays are checked element-wise.
+	if strings.HasPrefix(c.UDTName, "_") {
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			if _, ok := expectation(t); !ok {
+				return ""
+			}
+			return fmt.Sprintf("field type %v is incompatible with array column type %v", t, c.UDTName)
+		}
+		elem := c
+		elem.UDTName = strings.TrimPrefix(c.UDTName, "_")
+		elem.Nullable = false
+		if reason := checkFieldType(t.Elem(), elem, strictness, writeOverflow); reason != "" {
+			return fmt.Sprintf("array element: %v", reason)
+		}
+		return ""
+	}
+
+	e, ok := expectation(t)
+	if !ok {
+		return ""
+	}
+	family, ok := typeFamilies[c.UDTName]
+	if !ok {
+		// Enums, domains and other user-defined types.
+		return ""
+	}
+	if strictness == ValidateStrictnessExact {
+		if !e.acceptsExact(c.UDTName) {
+			return fmt.Sprintf("field type %v does not match column type %v", t, c.UDTName)
+		}
+		return ""
+	}
+	if !e.acceptsFamily(family) {
+		return fmt.Sprintf("field type %v is incompatible with column type %v", t, c.UDTName)
+	}
+	if bits, ok := integerBits[c.UDTName]; ok && t != durationType {
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if t.Bits() < bits {
+				return fmt.Sprintf("column type %v may overflow field type %v", c.UDTName, t)
+			}
+			if writeOverflow && t.Bits() > bits {
+				return fmt.Sprintf("field type %v may overflow column type %v when written", t, c.UDTName)
+			}
+		}
+	}
+	return ""
+}